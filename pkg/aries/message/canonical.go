@@ -0,0 +1,44 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CanonicalJSON returns the canonical form of the JSON document: object keys sorted, insignificant whitespace
+// removed and numbers kept as written, so that equal documents have equal bytes whatever the marshaler.
+func CanonicalJSON(doc []byte) ([]byte, error) {
+	var v interface{}
+
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+
+	err := d.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal json : %w", err)
+	}
+
+	if d.More() {
+		return nil, fmt.Errorf("unmarshal json : unexpected data after the document")
+	}
+
+	buf := &bytes.Buffer{}
+
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+
+	// maps are encoded with sorted keys
+	err = e.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json : %w", err)
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
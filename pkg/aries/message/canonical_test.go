@@ -0,0 +1,58 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package message
+
+import (
+	"testing"
+
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("same did doc marshaled twice", func(t *testing.T) {
+		t.Parallel()
+
+		doc := mockdiddoc.GetMockDIDDoc(t, false)
+
+		first, err := doc.JSONBytes()
+		require.NoError(t, err)
+
+		second, err := doc.JSONBytes()
+		require.NoError(t, err)
+
+		firstCanonical, err := CanonicalJSON(first)
+		require.NoError(t, err)
+
+		secondCanonical, err := CanonicalJSON(second)
+		require.NoError(t, err)
+
+		require.Equal(t, firstCanonical, secondCanonical)
+		require.JSONEq(t, string(first), string(firstCanonical))
+	})
+
+	t.Run("key order and whitespace", func(t *testing.T) {
+		t.Parallel()
+
+		canonical, err := CanonicalJSON([]byte(`{ "b": [1.50, {"d": "<x>", "c": null}], "a": true }`))
+		require.NoError(t, err)
+		require.Equal(t, `{"a":true,"b":[1.50,{"c":null,"d":"<x>"}]}`, string(canonical))
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := CanonicalJSON([]byte(`{"a":`))
+		require.Error(t, err)
+
+		_, err = CanonicalJSON([]byte(`{"a":1} {"b":2}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unexpected data after the document")
+	})
+}
@@ -0,0 +1,64 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package message
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+)
+
+// Messenger is a service.Messenger whose replies can be bounded by a context.
+type Messenger interface {
+	service.Messenger
+	// ReplyToWithContext replies to the message by given msgID, like ReplyTo, and returns the context error if the
+	// context is done first.
+	ReplyToWithContext(ctx context.Context, msgID string, msg service.DIDCommMsgMap, opts ...service.Opt) error
+}
+
+// NewMessenger returns the messenger as is if it is a Messenger, else a Messenger wrapping it.
+func NewMessenger(messenger service.Messenger) Messenger {
+	if m, ok := messenger.(Messenger); ok {
+		return m
+	}
+
+	return &contextMessenger{Messenger: messenger}
+}
+
+// contextMessenger bounds the ReplyTo of a messenger that doesn't take a context.
+type contextMessenger struct {
+	service.Messenger
+}
+
+// ReplyToWithContext calls ReplyTo in a new goroutine and waits until it returns or the context is done. In the
+// latter case the reply may still be sent, its result is discarded. A panic in ReplyTo is returned as an error.
+func (m *contextMessenger) ReplyToWithContext(ctx context.Context, msgID string, msg service.DIDCommMsgMap,
+	opts ...service.Opt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("reply panic : %v", r)
+			}
+		}()
+
+		errCh <- m.Messenger.ReplyTo(msgID, msg, opts...) // nolint:staticcheck //issue#403
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
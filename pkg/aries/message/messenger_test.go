@@ -0,0 +1,125 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package message
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+type mockContextMessenger struct {
+	messenger.MockMessenger
+}
+
+func (m *mockContextMessenger) ReplyToWithContext(context.Context, string, service.DIDCommMsgMap,
+	...service.Opt) error {
+	return nil
+}
+
+func TestMessenger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reply", func(t *testing.T) {
+		t.Parallel()
+
+		var replyTo string
+
+		m := NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, _ service.DIDCommMsgMap, _ ...service.Opt) error {
+				replyTo = msgID
+
+				return nil
+			},
+		})
+
+		require.NoError(t, m.ReplyToWithContext(context.Background(), "msg-1", service.DIDCommMsgMap{}))
+		require.Equal(t, "msg-1", replyTo)
+	})
+
+	t.Run("reply error", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				return errors.New("outbound failure")
+			},
+		})
+
+		err := m.ReplyToWithContext(context.Background(), "msg-1", service.DIDCommMsgMap{})
+		require.EqualError(t, err, "outbound failure")
+	})
+
+	t.Run("context done before reply returns", func(t *testing.T) {
+		t.Parallel()
+
+		block := make(chan struct{})
+		defer close(block)
+
+		m := NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				<-block
+
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := m.ReplyToWithContext(ctx, "msg-1", service.DIDCommMsgMap{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("context already done", func(t *testing.T) {
+		t.Parallel()
+
+		var called bool
+
+		m := NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				called = true
+
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := m.ReplyToWithContext(ctx, "msg-1", service.DIDCommMsgMap{})
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, called)
+	})
+
+	t.Run("reply panic", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				panic("outbound failure")
+			},
+		})
+
+		err := m.ReplyToWithContext(context.Background(), "msg-1", service.DIDCommMsgMap{})
+		require.EqualError(t, err, "reply panic : outbound failure")
+	})
+
+	t.Run("context aware messenger used as is", func(t *testing.T) {
+		t.Parallel()
+
+		m := &mockContextMessenger{}
+
+		require.Same(t, m, NewMessenger(m))
+	})
+}
@@ -6,7 +6,15 @@ SPDX-License-Identifier: Apache-2.0
 
 package message
 
-import "github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+import (
+	"errors"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+)
+
+// ErrMsgSvcClosed is returned by HandleInbound once the msg service is closed.
+var ErrMsgSvcClosed = errors.New("message service closed")
 
 // Msg model.
 type Msg struct {
@@ -18,16 +26,24 @@ type Msg struct {
 // MsgService msg service implementation.
 type MsgService struct {
 	svcName string
-	msgType string
+	accept  func(msgType string) bool
 	msgCh   chan Msg
+	done    chan struct{}
+	once    sync.Once
 }
 
 // NewMsgSvc new msg service.
 func NewMsgSvc(name, msgType string, msgCh chan Msg) *MsgService {
+	return NewMsgSvcWithMatcher(name, func(t string) bool { return t == msgType }, msgCh)
+}
+
+// NewMsgSvcWithMatcher new msg service handling the message types accepted by the given matcher.
+func NewMsgSvcWithMatcher(name string, accept func(msgType string) bool, msgCh chan Msg) *MsgService {
 	return &MsgService{
 		svcName: name,
-		msgType: msgType,
+		accept:  accept,
 		msgCh:   msgCh,
+		done:    make(chan struct{}),
 	}
 }
 
@@ -38,18 +54,33 @@ func (m *MsgService) Name() string {
 
 // Accept validates whether the service handles msgType and purpose.
 func (m *MsgService) Accept(msgType string, _ []string) bool {
-	return m.msgType == msgType
+	return m.accept(msgType)
 }
 
-// HandleInbound handles inbound didcomm msg.
+// HandleInbound handles inbound didcomm msg. Once the service is closed the msg is dropped, the msgs
+// not yet delivered to the channel included.
 func (m *MsgService) HandleInbound(msg service.DIDCommMsg, ctx service.DIDCommContext) (string, error) {
+	select {
+	case <-m.done:
+		return "", ErrMsgSvcClosed
+	default:
+	}
+
 	go func() {
-		m.msgCh <- Msg{
+		select {
+		case m.msgCh <- Msg{
 			DIDCommMsg: msg,
 			MyDID:      ctx.MyDID(),
 			TheirDID:   ctx.TheirDID(),
+		}:
+		case <-m.done:
 		}
 	}()
 
 	return "", nil
 }
+
+// Close closes the msg service, it is safe to call more than once.
+func (m *MsgService) Close() {
+	m.once.Do(func() { close(m.done) })
+}
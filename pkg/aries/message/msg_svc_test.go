@@ -7,11 +7,13 @@ SPDX-License-Identifier: Apache-2.0
 package message
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
 )
 
 func TestNewMsgSvc(t *testing.T) {
@@ -49,3 +51,40 @@ func TestNewMsgSvc(t *testing.T) {
 		require.Fail(t, "tests are not validated due to timeout")
 	}
 }
+
+func TestNewMsgSvcWithMatcher(t *testing.T) {
+	t.Parallel()
+
+	name := "msg-123"
+	msgCh := make(chan Msg)
+
+	msgSvc := NewMsgSvcWithMatcher(name, func(msgType string) bool {
+		return strings.HasPrefix(msgType, "http://example.com/message/")
+	}, msgCh)
+	require.Equal(t, name, msgSvc.Name())
+
+	require.True(t, msgSvc.Accept("http://example.com/message/test", nil))
+	require.True(t, msgSvc.Accept("http://example.com/message/other", nil))
+	require.False(t, msgSvc.Accept("http://example.com/other/test", nil))
+}
+
+func TestMsgServiceClose(t *testing.T) { // nolint:paralleltest // goleak requires no concurrently running tests
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	msgType := "http://example.com/message/test"
+	msgSvc := NewMsgSvc("msg-123", msgType, make(chan Msg))
+
+	msg := service.NewDIDCommMsgMap(struct {
+		Type string `json:"@type,omitempty"`
+	}{Type: msgType})
+
+	// nobody reads the channel, the pending msg is dropped on close
+	_, err := msgSvc.HandleInbound(msg, service.EmptyDIDCommContext())
+	require.NoError(t, err)
+
+	msgSvc.Close()
+	msgSvc.Close()
+
+	_, err = msgSvc.HandleInbound(msg, service.EmptyDIDCommContext())
+	require.ErrorIs(t, err, ErrMsgSvcClosed)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/edge-adapter/pkg/route"
+)
+
+const (
+	storeName = "routerdids"
+)
+
+// Store is the audit log of the router DIDs created by the adapter. It implements route.AuditStore.
+type Store struct {
+	Store storage.Store
+}
+
+// New returns the Store.
+func New(p storage.Provider) (*Store, error) {
+	store, err := p.OpenStore(storeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store : %w", err)
+	}
+
+	return &Store{Store: store}, nil
+}
+
+// RecordDIDCreated saves the record of the router DID.
+func (s *Store) RecordDIDCreated(_ context.Context, record *route.DIDCreatedRecord) error {
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal did created record : %w", err)
+	}
+
+	return s.Store.Put(didKey(record.DID), bits) // nolint:wrapcheck // reduce cyclo
+}
+
+// GetDIDCreated fetches the record of the router DID.
+func (s *Store) GetDIDCreated(did string) (*route.DIDCreatedRecord, error) {
+	bits, err := s.Store.Get(didKey(did))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch did created record for %s : %w", did, err)
+	}
+
+	result := &route.DIDCreatedRecord{}
+
+	err = json.Unmarshal(bits, result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal did created record : %w", err)
+	}
+
+	return result, nil
+}
+
+func didKey(did string) string {
+	return fmt.Sprintf("%s_did_%s", storeName, did)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/route"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns instance", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := New(mem.NewProvider())
+		require.NoError(t, err)
+		require.NotNil(t, s)
+	})
+
+	t.Run("wraps error opening store", func(t *testing.T) {
+		t.Parallel()
+
+		expected := errors.New("test")
+		_, err := New(&mockstorage.Provider{ErrOpenStore: expected})
+		require.Error(t, err)
+		require.True(t, errors.Is(err, expected))
+	})
+}
+
+func TestStore_RecordDIDCreated(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records did", func(t *testing.T) {
+		t.Parallel()
+
+		expected := &route.DIDCreatedRecord{
+			MsgID:     uuid.New().String(),
+			DID:       "did:peer:" + uuid.New().String(),
+			TheirDID:  "did:peer:" + uuid.New().String(),
+			Endpoint:  "https://adapter.example.com",
+			CreatedAt: time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC),
+		}
+
+		s, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		var _ route.AuditStore = s
+
+		err = s.RecordDIDCreated(context.Background(), expected)
+		require.NoError(t, err)
+
+		result, err := s.GetDIDCreated(expected.DID)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+
+	t.Run("wraps storage error", func(t *testing.T) {
+		t.Parallel()
+
+		expected := errors.New("test")
+
+		s, err := New(&mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{ErrPut: expected}})
+		require.NoError(t, err)
+
+		err = s.RecordDIDCreated(context.Background(), &route.DIDCreatedRecord{DID: "did:peer:1"})
+		require.True(t, errors.Is(err, expected))
+	})
+}
+
+func TestStore_GetDIDCreated(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		_, err = s.GetDIDCreated("did:peer:1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+	})
+
+	t.Run("unmarshal error", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		require.NoError(t, s.Store.Put(didKey("did:peer:1"), []byte("{")))
+
+		_, err = s.GetDIDCreated("did:peer:1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal did created record")
+	})
+}
@@ -21,6 +21,7 @@ type MockClient struct {
 	CreateInvFunc        func(string) (*didexchange.Invitation, error)
 	GetConnectionErr     error
 	CreateConnectionFunc func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error)
+	QueryConnectionsFunc func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error)
 }
 
 // RegisterActionEvent registers the action event channel.
@@ -69,3 +70,12 @@ func (s *MockClient) GetConnection(connectionID string) (*didexchange.Connection
 
 	return &didexchange.Connection{Record: &connection.Record{ConnectionID: connectionID}}, nil
 }
+
+// QueryConnections returns the connections matching the params.
+func (s *MockClient) QueryConnections(params *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+	if s.QueryConnectionsFunc != nil {
+		return s.QueryConnectionsFunc(params)
+	}
+
+	return nil, nil
+}
@@ -10,12 +10,20 @@ import mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protoc
 
 // MockClient mock mediator client.
 type MockClient struct {
-	RegisterErr   error
-	GetConfigFunc func(connID string) (*mediatorsvc.Config, error)
+	RegisterErr    error
+	RegisterFunc   func(connectionID string) error
+	UnregisterErr  error
+	UnregisterFunc func(connectionID string) error
+	GetConfigFunc  func(connID string) (*mediatorsvc.Config, error)
+	ConfigFunc     func() ([]string, string, error)
 }
 
 // Register registers with the router.
 func (c *MockClient) Register(connectionID string) error {
+	if c.RegisterFunc != nil {
+		return c.RegisterFunc(connectionID)
+	}
+
 	if c.RegisterErr != nil {
 		return c.RegisterErr
 	}
@@ -23,7 +31,25 @@ func (c *MockClient) Register(connectionID string) error {
 	return nil
 }
 
+// Unregister removes the registration with the router.
+func (c *MockClient) Unregister(connectionID string) error {
+	if c.UnregisterFunc != nil {
+		return c.UnregisterFunc(connectionID)
+	}
+
+	return c.UnregisterErr
+}
+
 // GetConfig gets the router config.
 func (c *MockClient) GetConfig(connID string) (*mediatorsvc.Config, error) {
 	return c.GetConfigFunc(connID)
 }
+
+// Config gets the routing keys and endpoint of the router.
+func (c *MockClient) Config() ([]string, string, error) {
+	if c.ConfigFunc != nil {
+		return c.ConfigFunc()
+	}
+
+	return nil, "", nil
+}
@@ -17,9 +17,9 @@ type MockMessenger struct {
 }
 
 // ReplyTo reply to a message.
-func (m *MockMessenger) ReplyTo(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+func (m *MockMessenger) ReplyTo(msgID string, msg service.DIDCommMsgMap, opts ...service.Opt) error {
 	if m.ReplyToFunc != nil {
-		return m.ReplyToFunc(msgID, msg)
+		return m.ReplyToFunc(msgID, msg, opts...)
 	}
 
 	return nil
@@ -19,6 +19,7 @@ import (
 	mocksvc "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/didexchange"
 	mockroute "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/mediator"
 	ariesmockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
 	"github.com/stretchr/testify/require"
 
 	"github.com/trustbloc/edge-adapter/pkg/internal/mock/issuecredential"
@@ -40,6 +41,8 @@ func TestNew(t *testing.T) {
 			Provider: &ariesmockprovider.Provider{
 				ProtocolStateStorageProviderValue: mem.NewProvider(),
 				StorageProviderValue:              mem.NewProvider(),
+				ServiceEndpointValue:              "https://issuer.example.com",
+				VDRegistryValue:                   &mockvdr.MockVDRegistry{},
 				ServiceMap: map[string]interface{}{
 					didexchange.DIDExchange: &mocksvc.MockDIDExchangeSvc{},
 					mediator.Coordination:   &mockroute.MockMediatorSvc{},
@@ -2133,7 +2133,7 @@ func mediatorClient(prov mediatorClientProvider) (route.Mediator, error) {
 		return nil, fmt.Errorf("failed to create new mediator client: %w", err)
 	}
 
-	return c, nil
+	return route.NewMediator(c), nil
 }
 
 func issueCredentialClient(prov issuecredential.Provider, actionCh chan service.DIDCommAction) (*issuecredential.Client, error) { // nolint: lll
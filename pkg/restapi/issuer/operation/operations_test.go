@@ -234,6 +234,8 @@ func TestNew(t *testing.T) {
 					FailNamespace: "walletappprofile",
 				},
 				ProtocolStateStorageProviderValue: mockstore.NewMockStoreProvider(),
+				ServiceEndpointValue:              "https://issuer.example.com",
+				VDRegistryValue:                   &mockvdr.MockVDRegistry{},
 				ServiceMap: map[string]interface{}{
 					outofbandsvc.Name:       &mockoutofband.MockService{},
 					mediator.Coordination:   &mockroute.MockMediatorSvc{},
@@ -1038,7 +1040,8 @@ func TestConnectWallet(t *testing.T) { // nolint:tparallel // data race
 					outofbandv2svc.Name:     &mockoutofbandv2.MockService{},
 				},
 				KMSValue:             &mockkms.KeyManager{CrAndExportPubKeyErr: errors.New("key generation error")},
-				ServiceEndpointValue: "endpoint",
+				ServiceEndpointValue: "https://issuer.example.com",
+				VDRegistryValue:      &mockvdr.MockVDRegistry{},
 			},
 		}
 
@@ -2572,7 +2575,7 @@ func TestIssueCredentialHandler(t *testing.T) {
 						outofbandsvc.Name:       &mockoutofband.MockService{},
 						outofbandv2svc.Name:     &mockoutofbandv2.MockService{},
 					},
-					ServiceEndpointValue: "endpoint",
+					ServiceEndpointValue: "https://issuer.example.com",
 					VDRegistryValue: &mockvdr.MockVDRegistry{
 						CreateErr: errors.New("did create error"),
 					},
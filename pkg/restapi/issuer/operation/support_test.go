@@ -75,7 +75,7 @@ func getAriesCtx(t *testing.T) aries.CtxProvider {
 			},
 			KMSValue:             &mockkms.KeyManager{ImportPrivateKeyErr: fmt.Errorf("error import priv key")},
 			CryptoValue:          &mockcrypto.Crypto{},
-			ServiceEndpointValue: "endpoint",
+			ServiceEndpointValue: "https://issuer.example.com",
 			VDRegistryValue: &mockvdri.MockVDRegistry{
 				CreateValue:  mockdiddoc.GetMockDIDDoc("did:example:def567"),
 				ResolveValue: mockdiddoc.GetMockDIDDoc("did:example:def567"),
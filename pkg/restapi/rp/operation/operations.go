@@ -1855,7 +1855,7 @@ func createRouteSvc(config *Config, connectionLookup connectionRecorder) (routeS
 		AriesMessenger:    config.AriesMessenger,
 		MsgRegistrar:      config.MsgRegistrar,
 		DIDExchangeClient: config.DIDExchClient,
-		MediatorClient:    mediatorClient,
+		MediatorClient:    route.NewMediator(mediatorClient),
 		ServiceEndpoint:   config.AriesContextProvider.ServiceEndpoint(),
 		Store:             config.Storage.Transient,
 		ConnectionLookup:  connectionLookup,
@@ -252,6 +252,8 @@ func TestNew(t *testing.T) {
 			Provider: &ariesmockprovider.Provider{
 				ProtocolStateStorageProviderValue: mem.NewProvider(),
 				StorageProviderValue:              mem.NewProvider(),
+				ServiceEndpointValue:              "https://adapter.example.com",
+				VDRegistryValue:                   &mockvdr.MockVDRegistry{},
 				ServiceMap: map[string]interface{}{
 					mediator.Coordination:      &mockroute.MockMediatorSvc{},
 					didexchangesvc.DIDExchange: &mockdidexsvc.MockDIDExchangeSvc{},
@@ -414,6 +416,8 @@ func TestListenForConnectionCompleteEvents(t *testing.T) {
 						},
 					},
 					ProtocolStateStorageProviderValue: mem.NewProvider(),
+					ServiceEndpointValue:              "https://adapter.example.com",
+					VDRegistryValue:                   &mockvdr.MockVDRegistry{},
 					ServiceMap: map[string]interface{}{
 						mediator.Coordination:      &mockroute.MockMediatorSvc{},
 						didexchangesvc.DIDExchange: &mockdidexsvc.MockDIDExchangeSvc{},
@@ -548,6 +552,8 @@ func TestListenForConnectionCompleteEvents(t *testing.T) {
 					},
 				},
 				ProtocolStateStorageProviderValue: mem.NewProvider(),
+				ServiceEndpointValue:              "https://adapter.example.com",
+				VDRegistryValue:                   &mockvdr.MockVDRegistry{},
 				ServiceMap: map[string]interface{}{
 					mediator.Coordination:      &mockroute.MockMediatorSvc{},
 					didexchangesvc.DIDExchange: &mockdidexsvc.MockDIDExchangeSvc{},
@@ -3885,6 +3891,7 @@ func TestDIDDocReq(t *testing.T) { // nolint:gocyclo,cyclop
 				ProtocolStateStorageProviderValue: mem.NewProvider(),
 				StorageProviderValue:              mem.NewProvider(),
 				VDRegistryValue:                   &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")},
+				ServiceEndpointValue:              "https://adapter.example.com",
 				ServiceMap: map[string]interface{}{
 					mediator.Coordination:      &mockroute.MockMediatorSvc{},
 					didexchangesvc.DIDExchange: &mockdidexsvc.MockDIDExchangeSvc{},
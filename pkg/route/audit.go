@@ -0,0 +1,37 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"time"
+)
+
+// DIDCreatedRecord is the audit record of a router DID created for a diddoc-req.
+type DIDCreatedRecord struct {
+	// MsgID is the id of the diddoc-req.
+	MsgID string `json:"msgID"`
+	// DID is the router DID.
+	DID string `json:"did"`
+	// TheirDID is the DID of the client that sent the diddoc-req, empty if unknown.
+	TheirDID string `json:"theirDID,omitempty"`
+	// Endpoint is the service endpoint of the router DID.
+	Endpoint string `json:"endpoint"`
+	// CreatedAt is when the router DID was created.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuditStore keeps a durable record of the router DIDs handed out.
+type AuditStore interface {
+	RecordDIDCreated(ctx context.Context, record *DIDCreatedRecord) error
+}
+
+type noopAuditStore struct{}
+
+func (noopAuditStore) RecordDIDCreated(context.Context, *DIDCreatedRecord) error {
+	return nil
+}
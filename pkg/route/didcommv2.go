@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+)
+
+// DIDComm v1 and v2 message fields. The handlers and models use the v1 ones, v2 messages are converted on the way
+// in and their replies on the way out. The v2 body holds the fields of the v1 message other than its headers, eg.
+// the data of a register-route-req.
+const (
+	jsonIDV1           = "@id"
+	jsonTypeV1         = "@type"
+	jsonThreadV1       = "~thread"
+	jsonIDV2           = "id"
+	jsonTypeV2         = "type"
+	jsonBodyV2         = "body"
+	jsonThreadID       = "thid"
+	jsonParentThreadID = "pthid"
+	jsonMetadata       = "_internal_metadata"
+)
+
+// didCommV2Headers are the DIDComm v2 headers other than the ones converted, fromDIDCommV2 keeps them at the top
+// level of the v1 message.
+var didCommV2Headers = []string{
+	"typ", "from", "to", "created_time", "expires_time", "from_prior", "please_ack", "ack", "lang", "attachments",
+}
+
+// didCommV2Msg returns the message map and true if the message has the DIDComm v2 envelope.
+func didCommV2Msg(msg service.DIDCommMsg) (service.DIDCommMsgMap, bool) {
+	msgMap, ok := msg.(service.DIDCommMsgMap)
+	if !ok {
+		return nil, false
+	}
+
+	v2, err := service.IsDIDCommV2(&msgMap)
+
+	return msgMap, err == nil && v2
+}
+
+// fromDIDCommV2 returns the DIDComm v1 form of the v2 message: id, type, thid and pthid become @id, @type and the
+// ~thread decorator and the body fields are moved to the top level.
+func fromDIDCommV2(msg service.DIDCommMsgMap) service.DIDCommMsgMap {
+	v1 := service.DIDCommMsgMap{}
+
+	if body, ok := msg[jsonBodyV2].(map[string]interface{}); ok {
+		for k, v := range body {
+			v1[k] = v
+		}
+	}
+
+	for k, v := range msg {
+		switch k {
+		case jsonIDV2:
+			v1[jsonIDV1] = v
+		case jsonTypeV2:
+			v1[jsonTypeV1] = v
+		case jsonBodyV2, jsonThreadID, jsonParentThreadID:
+		default:
+			v1[k] = v
+		}
+	}
+
+	thread := map[string]interface{}{}
+
+	for _, k := range []string{jsonThreadID, jsonParentThreadID} {
+		if v, ok := msg[k].(string); ok && v != "" {
+			thread[k] = v
+		}
+	}
+
+	if len(thread) > 0 {
+		v1[jsonThreadV1] = thread
+	}
+
+	return v1
+}
+
+// toDIDCommV2 returns the DIDComm v2 form of the v1 reply, the inverse of fromDIDCommV2.
+func toDIDCommV2(msg service.DIDCommMsgMap) service.DIDCommMsgMap {
+	v2 := service.DIDCommMsgMap{}
+	body := map[string]interface{}{}
+
+	for k, v := range msg {
+		switch k {
+		case jsonIDV1:
+			v2[jsonIDV2] = v
+		case jsonTypeV1:
+			v2[jsonTypeV2] = v
+		case jsonMetadata:
+			v2[k] = v
+		case jsonThreadV1:
+			thread, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			for _, tk := range []string{jsonThreadID, jsonParentThreadID} {
+				if tv, ok := thread[tk].(string); ok && tv != "" {
+					v2[tk] = tv
+				}
+			}
+		default:
+			body[k] = v
+		}
+	}
+
+	v2[jsonBodyV2] = body
+
+	return v2
+}
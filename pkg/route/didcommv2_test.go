@@ -0,0 +1,187 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	mockdidex "github.com/trustbloc/edge-adapter/pkg/internal/mock/didexchange"
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+func TestDIDCommV2(t *testing.T) {
+	t.Parallel()
+
+	// reply is a reply sent by the service and whether it was sent as a DIDComm v2 message.
+	type reply struct {
+		msg service.DIDCommMsgMap
+		v2  bool
+	}
+
+	newService := func(t *testing.T) (*Service, chan reply) {
+		t.Helper()
+
+		replies := make(chan reply, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, opts ...service.Opt) error {
+				// the messenger sets the thread in the v2 form with the v2 version option
+				thread := service.DIDCommMsgMap{}
+				thread.SetThread("thid", "", opts...)
+
+				_, v2 := thread[jsonThreadID]
+
+				replies <- reply{msg: msg, v2: v2}
+
+				return nil
+			},
+		}
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { require.NoError(t, c.Close(context.Background())) })
+
+		return c, replies
+	}
+
+	t.Run("diddoc-req and register-route-req", func(t *testing.T) {
+		t.Parallel()
+
+		c, replies := newService(t)
+
+		txnID := uuid.New().String()
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.DIDCommMsgMap{
+			"id":   txnID,
+			"type": DIDDocReqMsgType,
+			"body": map[string]interface{}{},
+		}})
+
+		r := <-replies
+		require.True(t, r.v2)
+		require.Equal(t, DIDDocRespMsgType, r.msg["type"])
+		require.NotEmpty(t, r.msg["id"])
+		require.NotContains(t, r.msg, "@id")
+		require.NotContains(t, r.msg, "@type")
+		require.NotContains(t, r.msg, "data")
+
+		resp := &DIDDocResp{}
+		require.NoError(t, fromDIDCommV2(r.msg).Decode(resp))
+		require.NotNil(t, resp.Data)
+
+		_, err := did.ParseDocument(resp.Data.DIDDoc)
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		req := toDIDCommV2(service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Data: &ConnReqData{DIDDoc: didDocBytes},
+		}))
+		req["pthid"] = txnID
+
+		c.handleMsg(message.Msg{DIDCommMsg: req})
+
+		r = <-replies
+		require.True(t, r.v2)
+		require.Equal(t, RegisterRouteRespMsgType, r.msg["type"])
+
+		connResp := &ConnResp{}
+		require.NoError(t, fromDIDCommV2(r.msg).Decode(connResp))
+		require.NotNil(t, connResp.Data)
+		require.NotEmpty(t, connResp.Data.ConnectionID)
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		t.Parallel()
+
+		c, replies := newService(t)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.DIDCommMsgMap{
+			"id":    uuid.New().String(),
+			"type":  RegisterRouteReqMsgType,
+			"pthid": uuid.New().String(),
+			"body":  map[string]interface{}{},
+		}})
+
+		r := <-replies
+		require.True(t, r.v2)
+		require.Equal(t, RegisterRouteRespMsgType, r.msg["type"])
+
+		errResp := &ErrorResp{}
+		require.NoError(t, fromDIDCommV2(r.msg).Decode(errResp))
+		require.NotEmpty(t, errResp.Data.Code)
+	})
+
+	t.Run("v1 unchanged", func(t *testing.T) {
+		t.Parallel()
+
+		c, replies := newService(t)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		r := <-replies
+		require.False(t, r.v2)
+		require.Equal(t, DIDDocRespMsgType, r.msg["@type"])
+		require.Contains(t, r.msg, "data")
+		require.NotContains(t, r.msg, "body")
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		v1 := service.NewDIDCommMsgMap(PingResp{
+			ID:        uuid.New().String(),
+			Type:      PingRespMsgType,
+			Thread:    &decorator.Thread{ID: uuid.New().String(), PID: uuid.New().String()},
+			Timestamp: "2021-01-01T00:00:00Z",
+		})
+
+		v2 := toDIDCommV2(v1)
+		require.Equal(t, v1.ID(), v2["id"])
+		require.Equal(t, PingRespMsgType, v2["type"])
+		require.Equal(t, map[string]interface{}{"timestamp": "2021-01-01T00:00:00Z"}, v2["body"])
+
+		thid, err := v2.ThreadID()
+		require.NoError(t, err)
+
+		v1thid, err := v1.ThreadID()
+		require.NoError(t, err)
+		require.Equal(t, v1thid, thid)
+		require.Equal(t, v1.ParentThreadID(), v2.ParentThreadID())
+
+		_, isV2 := didCommV2Msg(v2)
+		require.True(t, isV2)
+
+		_, isV2 = didCommV2Msg(v1)
+		require.False(t, isV2)
+
+		require.Equal(t, v1, fromDIDCommV2(v2))
+	})
+}
@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+)
+
+const defaultDIDDocCacheSize = 128
+
+// didDocCache is an LRU cache of parsed did docs keyed by the hash of their raw bytes. Entries are only evicted
+// when the cache is full; the raw bytes identify the doc, so an entry never goes stale. Each parse returns its own
+// copy of the cached doc, which the caller is free to modify.
+type didDocCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+}
+
+type didDocCacheEntry struct {
+	key [sha256.Size]byte
+	doc *did.Doc
+}
+
+// newDIDDocCache returns a cache holding up to size docs. A size lower than 1 disables caching.
+func newDIDDocCache(size int) *didDocCache {
+	return &didDocCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// parse returns the parsed did doc, from the cache if the same bytes were parsed before.
+func (c *didDocCache) parse(raw []byte) (*did.Doc, error) {
+	if c.size < 1 {
+		return did.ParseDocument(raw)
+	}
+
+	key := sha256.Sum256(raw)
+
+	c.mu.Lock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+
+		return e.Value.(*didDocCacheEntry).clone(), nil
+	}
+
+	c.mu.Unlock()
+
+	doc, err := did.ParseDocument(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		// parsed concurrently
+		c.lru.MoveToFront(e)
+
+		return e.Value.(*didDocCacheEntry).clone(), nil
+	}
+
+	entry := &didDocCacheEntry{key: key, doc: doc}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*didDocCacheEntry).key)
+	}
+
+	return entry.clone(), nil
+}
+
+func (c *didDocCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// clone returns a deep copy of the cached doc. The parsed verification method keys and the service endpoints are
+// left shared: they hold unexported state and are only read.
+func (e *didDocCacheEntry) clone() *did.Doc {
+	doc := *e.doc
+
+	doc.Context = cloneJSONValue(doc.Context)
+	doc.AlsoKnownAs = cloneStrings(doc.AlsoKnownAs)
+	doc.Created = cloneTime(doc.Created)
+	doc.Updated = cloneTime(doc.Updated)
+
+	if doc.VerificationMethod != nil {
+		doc.VerificationMethod = make([]did.VerificationMethod, len(e.doc.VerificationMethod))
+		for i := range e.doc.VerificationMethod {
+			doc.VerificationMethod[i] = cloneVerificationMethod(e.doc.VerificationMethod[i])
+		}
+	}
+
+	if doc.Service != nil {
+		doc.Service = make([]did.Service, len(e.doc.Service))
+		for i, svc := range e.doc.Service {
+			svc.RecipientKeys = cloneStrings(svc.RecipientKeys)
+			svc.RoutingKeys = cloneStrings(svc.RoutingKeys)
+			svc.Accept = cloneStrings(svc.Accept)
+
+			if props, ok := cloneJSONValue(svc.Properties).(map[string]interface{}); ok {
+				svc.Properties = props
+			}
+
+			doc.Service[i] = svc
+		}
+	}
+
+	doc.Authentication = cloneVerifications(doc.Authentication)
+	doc.AssertionMethod = cloneVerifications(doc.AssertionMethod)
+	doc.CapabilityDelegation = cloneVerifications(doc.CapabilityDelegation)
+	doc.CapabilityInvocation = cloneVerifications(doc.CapabilityInvocation)
+	doc.KeyAgreement = cloneVerifications(doc.KeyAgreement)
+
+	if doc.Proof != nil {
+		doc.Proof = make([]did.Proof, len(e.doc.Proof))
+		for i, proof := range e.doc.Proof {
+			proof.Created = cloneTime(proof.Created)
+			proof.ProofValue = cloneBytes(proof.ProofValue)
+			proof.Nonce = cloneBytes(proof.Nonce)
+			doc.Proof[i] = proof
+		}
+	}
+
+	return &doc
+}
+
+func cloneVerificationMethod(vm did.VerificationMethod) did.VerificationMethod {
+	vm.Value = cloneBytes(vm.Value)
+
+	return vm
+}
+
+func cloneVerifications(verifications []did.Verification) []did.Verification {
+	if verifications == nil {
+		return nil
+	}
+
+	clone := make([]did.Verification, len(verifications))
+	for i, v := range verifications {
+		v.VerificationMethod = cloneVerificationMethod(v.VerificationMethod)
+		clone[i] = v
+	}
+
+	return clone
+}
+
+// cloneJSONValue deep copies the maps and slices of a value decoded from JSON.
+func cloneJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+
+		clone := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			clone[k] = cloneJSONValue(val)
+		}
+
+		return clone
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+
+		clone := make([]interface{}, len(v))
+		for i, val := range v {
+			clone[i] = cloneJSONValue(val)
+		}
+
+		return clone
+	case []string:
+		return cloneStrings(v)
+	default:
+		return v
+	}
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string(nil), s...)
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte(nil), b...)
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	clone := *t
+
+	return &clone
+}
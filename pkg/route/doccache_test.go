@@ -0,0 +1,191 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/stretchr/testify/require"
+
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+)
+
+func TestDIDDocCache(t *testing.T) {
+	t.Parallel()
+
+	rawDoc := func(t *testing.T, id string) []byte {
+		t.Helper()
+
+		doc := mockdiddoc.GetMockDIDDoc(t, false)
+		doc.ID = id
+
+		raw, err := doc.JSONBytes()
+		require.NoError(t, err)
+
+		return raw
+	}
+
+	t.Run("cache hit", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDIDDocCache(2)
+		raw := rawDoc(t, "did:example:1")
+
+		first, err := c.parse(raw)
+		require.NoError(t, err)
+
+		second, err := c.parse(append([]byte(nil), raw...))
+		require.NoError(t, err)
+		require.NotSame(t, first, second)
+		require.Equal(t, 1, c.len())
+
+		expected, err := did.ParseDocument(raw)
+		require.NoError(t, err)
+		require.Equal(t, expected, first)
+		require.Equal(t, expected, second)
+	})
+
+	t.Run("cache hit returns a copy", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDIDDocCache(2)
+		raw := []byte(`{
+			"@context": ["https://www.w3.org/ns/did/v1"],
+			"id": "did:example:1",
+			"alsoKnownAs": ["did:example:2"],
+			"verificationMethod": [{
+				"id": "did:example:1#key-1",
+				"type": "Ed25519VerificationKey2018",
+				"controller": "did:example:1",
+				"publicKeyBase58": "B12NYF8RrR3h41TDCTJojY59usg3mbtbjnFs7Eud1Y6u"
+			}],
+			"authentication": ["did:example:1#key-1"],
+			"service": [{
+				"id": "did:example:1#did-communication",
+				"type": "did-communication",
+				"serviceEndpoint": "https://example.com",
+				"recipientKeys": ["did:example:1#key-1"],
+				"routingKeys": ["did:example:router#key-1"],
+				"nested": {"list": ["a"]}
+			}],
+			"created": "2020-01-01T00:00:00Z"
+		}`)
+
+		expected, err := did.ParseDocument(raw)
+		require.NoError(t, err)
+
+		first, err := c.parse(raw)
+		require.NoError(t, err)
+
+		first.Context.([]string)[0] = "https://example.com/modified"
+		first.AlsoKnownAs[0] = "did:example:modified"
+		first.VerificationMethod[0].Value[0]++
+		first.Authentication[0].VerificationMethod.Value[0]++
+		first.Service[0].RecipientKeys[0] = "did:example:modified#key-1"
+		first.Service[0].RoutingKeys[0] = "did:example:modified#key-1"
+		first.Service[0].Properties["nested"].(map[string]interface{})["list"].([]interface{})[0] = "b"
+		*first.Created = first.Created.Add(time.Hour)
+
+		second, err := c.parse(raw)
+		require.NoError(t, err)
+		require.Equal(t, expected, second)
+	})
+
+	t.Run("least recently used doc evicted", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDIDDocCache(2)
+		raw1, raw2, raw3 := rawDoc(t, "did:example:1"), rawDoc(t, "did:example:2"), rawDoc(t, "did:example:3")
+
+		_, err := c.parse(raw1)
+		require.NoError(t, err)
+
+		_, err = c.parse(raw2)
+		require.NoError(t, err)
+
+		// use 1 again, then 3 evicts 2
+		_, err = c.parse(raw1)
+		require.NoError(t, err)
+
+		_, err = c.parse(raw3)
+		require.NoError(t, err)
+		require.Equal(t, 2, c.len())
+		require.True(t, c.cached(raw1))
+		require.False(t, c.cached(raw2))
+		require.True(t, c.cached(raw3))
+	})
+
+	t.Run("invalid doc not cached", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDIDDocCache(2)
+
+		_, err := c.parse([]byte("{"))
+		require.Error(t, err)
+		require.Zero(t, c.len())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDIDDocCache(-1)
+		raw := rawDoc(t, "did:example:1")
+
+		first, err := c.parse(raw)
+		require.NoError(t, err)
+
+		second, err := c.parse(raw)
+		require.NoError(t, err)
+		require.NotSame(t, first, second)
+		require.Zero(t, c.len())
+	})
+}
+
+func (c *didDocCache) cached(raw []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.entries[sha256.Sum256(raw)]
+
+	return ok
+}
+
+func BenchmarkDIDDocCache(b *testing.B) {
+	raw := []byte(`{
+		"@context": ["https://w3id.org/did/v1"],
+		"id": "did:example:123",
+		"verificationMethod": [{
+			"controller": "did:example:123",
+			"id": "did:example:123#key-1",
+			"publicKeyBase58": "AAkCG2WD6wDQ2zeEQbYgHax8AUqWVfW6RCmKax7KrrRk",
+			"type": "Ed25519VerificationKey2018"
+		}],
+		"service": [{
+			"id": "did:example:123#did-communication",
+			"recipientKeys": ["did:example:123#key-1"],
+			"serviceEndpoint": "https://agent.example.com/",
+			"type": "did-communication"
+		}]
+	}`)
+
+	for _, size := range []int{-1, defaultDIDDocCacheSize} {
+		c := newDIDDocCache(size)
+
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := c.parse(raw)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
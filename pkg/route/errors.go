@@ -0,0 +1,92 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Error codes sent in ErrorRespData.Code. ErrCodeDIDCreationUnavailable is a transient did creation failure, eg. a
+// VDR outage, and the request may be sent again; ErrCodeDIDCreation is a permanent one, eg. an unsupported method.
+const (
+	ErrCodeInternal               = "internal-error"
+	ErrCodeUnsupportedMsgType     = "unsupported-msg-type"
+	ErrCodeRateLimited            = "rate-limited"
+	ErrCodeMsgExpired             = "msg-expired"
+	ErrCodeDIDCreation            = "did-creation-failed"
+	ErrCodeDIDCreationUnavailable = "did-creation-unavailable"
+	ErrCodeMediatorConfig         = "mediator-config-failed"
+	ErrCodeTxnSave                = "txn-save-failed"
+	ErrCodeAuditRecord            = "audit-record-failed"
+	ErrCodeTooManyTxns            = "too-many-pending-txns"
+	ErrCodeMsgParse               = "msg-parse-failed"
+	ErrCodeParentThreadIDMissing  = "parent-thread-id-missing"
+	ErrCodeDIDDocMissing          = "did-doc-missing"
+	ErrCodeDIDDocInvalid          = "did-doc-invalid"
+	ErrCodeDIDDocTooLarge         = "did-doc-too-large"
+	ErrCodeDIDDocUnverified       = "did-doc-unverified"
+	ErrCodeDIDMethodNotAllowed    = "did-method-not-allowed"
+	ErrCodeDIDDocTransform        = "did-doc-transform-failed"
+	ErrCodeAdapterDID             = "adapter-did"
+	ErrCodeTxnFetch               = "txn-fetch-failed"
+	ErrCodeTxnNotFound            = "txn-not-found"
+	ErrCodeRouteRegistered        = "route-already-registered"
+	ErrCodeConnectionCreation     = "connection-creation-failed"
+	ErrCodeRouteRegistration      = "route-registration-failed"
+	ErrCodeRouteNotFound          = "route-not-found"
+	ErrCodeRouteUnregistration    = "route-unregistration-failed"
+	ErrCodeConnectionLookup       = "connection-lookup-failed"
+	ErrCodeConnectionMappingSave  = "connection-mapping-save-failed"
+)
+
+// codedError is an error with the code reported to the client.
+type codedError struct {
+	code string
+	err  error
+}
+
+func withCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// errorCode returns the code of the error, or ErrCodeInternal if it has none.
+func errorCode(err error) string {
+	var cErr *codedError
+
+	if errors.As(err, &cErr) {
+		return cErr.code
+	}
+
+	return ErrCodeInternal
+}
+
+// isTransientErr reports whether the error is likely to go away if the call is made again, ie. it is a timeout,
+// a network error or reports itself as temporary.
+func isTransientErr(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var tempErr interface{ Temporary() bool }
+
+	return errors.As(err, &tempErr) && tempErr.Temporary()
+}
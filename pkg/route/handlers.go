@@ -0,0 +1,172 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/dispatcher"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/messaging/msghandler"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+)
+
+// MsgHandler handles a message of a type registered with Service.RegisterHandler and returns the reply. The context
+// is done once the handler timeout or the ~timing expiry of the message is reached.
+type MsgHandler func(ctx context.Context, msg service.DIDCommMsg) (service.DIDCommMsgMap, error)
+
+// customHandlers are the handlers registered after New, by message type.
+type customHandlers struct {
+	mu       sync.RWMutex
+	handlers map[string]MsgHandler
+	types    []string
+}
+
+func newCustomHandlers() *customHandlers {
+	return &customHandlers{handlers: map[string]MsgHandler{}}
+}
+
+func (c *customHandlers) add(msgType string, handler MsgHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.handlers[msgType]; ok {
+		return fmt.Errorf("handler already registered for message type %s", msgType)
+	}
+
+	c.handlers[msgType] = handler
+	c.types = append(c.types, msgType)
+
+	return nil
+}
+
+func (c *customHandlers) remove(msgType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.handlers, msgType)
+
+	for i, t := range c.types {
+		if t == msgType {
+			c.types = append(c.types[:i], c.types[i+1:]...)
+
+			break
+		}
+	}
+}
+
+func (c *customHandlers) get(msgType string) (MsgHandler, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	handler, ok := c.handlers[msgType]
+
+	return handler, ok
+}
+
+func (c *customHandlers) registeredTypes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]string(nil), c.types...)
+}
+
+// RegisterHandler registers the handler of the message type. The messages are received by a message service
+// with the given name and dispatched by the listener like the blinded routing ones, so they share
+// MaxConcurrentHandlers, the rate limit and the error replies. The message type must not be handled by the
+// service already.
+func (o *Service) RegisterHandler(name, msgType string, handler MsgHandler) error {
+	switch {
+	case name == "":
+		return errors.New("register handler : name is required")
+	case msgType == "":
+		return errors.New("register handler : message type is required")
+	case handler == nil:
+		return errors.New("register handler : handler is required")
+	case o.msgName(msgType) != "":
+		return fmt.Errorf("register handler : message type %s is handled by the service", msgType)
+	}
+
+	err := o.customHandlers.add(msgType, handler)
+	if err != nil {
+		return fmt.Errorf("register handler : %w", err)
+	}
+
+	err = o.msgServices.register(message.NewMsgSvc(name, msgType, o.msgCh))
+	if err != nil {
+		o.customHandlers.remove(msgType)
+
+		return fmt.Errorf("register handler : %w", err)
+	}
+
+	return nil
+}
+
+// msgServices are the message services registered by the service, unregistered on Close.
+type msgServices struct {
+	mu        sync.Mutex
+	registrar *msghandler.Registrar
+	svcs      []*message.MsgService
+	closed    bool
+}
+
+// register registers the message services, none of them if it fails.
+func (m *msgServices) register(svcs ...*message.MsgService) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errors.New("service is closed")
+	}
+
+	msgSvcs := make([]dispatcher.MessageService, len(svcs))
+	for i, svc := range svcs {
+		msgSvcs[i] = svc
+	}
+
+	err := m.registrar.Register(msgSvcs...)
+	if err != nil {
+		return fmt.Errorf("message service client: %w", err)
+	}
+
+	m.svcs = append(m.svcs, svcs...)
+
+	return nil
+}
+
+// unregisterAll unregisters and closes the message services, later registrations fail. The error lists the
+// services that could not be unregistered.
+func (m *msgServices) unregisterAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+
+	var errs []string
+
+	for _, svc := range m.svcs {
+		err := m.registrar.Unregister(svc.Name())
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+
+		svc.Close()
+	}
+
+	m.svcs = nil
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unregister message services : %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
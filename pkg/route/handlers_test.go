@@ -0,0 +1,212 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+const (
+	customReqMsgType  = "https://example.com/custom/1.0/status-req"
+	customRespMsgType = "https://example.com/custom/1.0/status-resp"
+)
+
+type customResp struct {
+	ID     string            `json:"@id,omitempty"`
+	Type   string            `json:"@type,omitempty"`
+	Thread *decorator.Thread `json:"~thread,omitempty"`
+	Status string            `json:"status,omitempty"`
+}
+
+func TestRegisterHandler(t *testing.T) {
+	t.Parallel()
+
+	// sendCustomReq delivers the custom request through the message service accepting it and returns the reply.
+	sendCustomReq := func(t *testing.T, config *Config, replies chan service.DIDCommMsgMap) service.DIDCommMsgMap {
+		t.Helper()
+
+		msg := service.NewDIDCommMsgMap(DIDDocReq{ID: uuid.New().String(), Type: customReqMsgType})
+
+		var delivered bool
+
+		for _, svc := range config.MsgRegistrar.Services() {
+			if svc.Accept(customReqMsgType, nil) {
+				_, err := svc.HandleInbound(msg, service.EmptyDIDCommContext())
+				require.NoError(t, err)
+
+				delivered = true
+			}
+		}
+
+		require.True(t, delivered)
+
+		return <-replies
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan service.DIDCommMsgMap, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		err = c.RegisterHandler("custom-status", customReqMsgType,
+			func(_ context.Context, msg service.DIDCommMsg) (service.DIDCommMsgMap, error) {
+				return service.NewDIDCommMsgMap(&customResp{
+					ID:     uuid.New().String(),
+					Type:   customRespMsgType,
+					Thread: &decorator.Thread{ID: msg.ID()},
+					Status: "up",
+				}), nil
+			})
+		require.NoError(t, err)
+
+		require.Equal(t, customReqMsgType, c.RegisteredTypes()[len(c.RegisteredTypes())-1])
+
+		resp := &customResp{}
+		require.NoError(t, sendCustomReq(t, config, replies).Decode(resp))
+		require.Equal(t, customRespMsgType, resp.Type)
+		require.Equal(t, "up", resp.Status)
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan service.DIDCommMsgMap, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		err = c.RegisterHandler("custom-status", customReqMsgType,
+			func(context.Context, service.DIDCommMsg) (service.DIDCommMsgMap, error) {
+				return nil, withCode(ErrCodeInternal, errors.New("status unavailable"))
+			})
+		require.NoError(t, err)
+
+		errResp := &ErrorResp{}
+		require.NoError(t, sendCustomReq(t, config, replies).Decode(errResp))
+		require.Equal(t, customReqMsgType, errResp.Type)
+		require.Equal(t, ErrCodeInternal, errResp.Data.Code)
+		require.Equal(t, "status unavailable", errResp.Data.ErrorMsg)
+	})
+
+	t.Run("handler timeout", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan service.DIDCommMsgMap, 1)
+
+		config := config()
+		config.HandlerTimeout = 10 * time.Millisecond
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		err = c.RegisterHandler("custom-status", customReqMsgType,
+			func(ctx context.Context, _ service.DIDCommMsg) (service.DIDCommMsgMap, error) {
+				<-ctx.Done()
+
+				return nil, ctx.Err()
+			})
+		require.NoError(t, err)
+
+		errResp := &ErrorResp{}
+		require.NoError(t, sendCustomReq(t, config, replies).Decode(errResp))
+		require.Equal(t, customReqMsgType, errResp.Type)
+		require.Contains(t, errResp.Data.ErrorMsg, context.DeadlineExceeded.Error())
+	})
+
+	t.Run("invalid args", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		handler := func(context.Context, service.DIDCommMsg) (service.DIDCommMsgMap, error) { return nil, nil }
+
+		err = c.RegisterHandler("", customReqMsgType, handler)
+		require.EqualError(t, err, "register handler : name is required")
+
+		err = c.RegisterHandler("custom-status", "", handler)
+		require.EqualError(t, err, "register handler : message type is required")
+
+		err = c.RegisterHandler("custom-status", customReqMsgType, nil)
+		require.EqualError(t, err, "register handler : handler is required")
+
+		err = c.RegisterHandler("custom-ping", PingMsgType, handler)
+		require.EqualError(t, err, "register handler : message type "+PingMsgType+" is handled by the service")
+
+		require.NoError(t, c.Close(context.Background()))
+
+		err = c.RegisterHandler("custom-status", customReqMsgType, handler)
+		require.EqualError(t, err, "register handler : service is closed")
+	})
+
+	t.Run("already registered", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		handler := func(context.Context, service.DIDCommMsg) (service.DIDCommMsgMap, error) { return nil, nil }
+
+		require.NoError(t, c.RegisterHandler("custom-status", customReqMsgType, handler))
+
+		err = c.RegisterHandler("custom-status-v2", customReqMsgType, handler)
+		require.EqualError(t, err,
+			"register handler : handler already registered for message type "+customReqMsgType)
+
+		// the message service name is taken, the handler is not kept
+		err = c.RegisterHandler("custom-status", customRespMsgType, handler)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "register handler : message service client")
+		require.NotContains(t, c.RegisteredTypes(), customRespMsgType)
+	})
+}
@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+)
+
+// Log field names.
+const (
+	logFieldMsgType            = "msg_type"
+	logFieldMsgID              = "msg_id"
+	logFieldParentThreadID     = "parent_thread_id"
+	logFieldConnectionID       = "connection_id"
+	logFieldRouterConnectionID = "router_connection_id"
+	logFieldError              = "error"
+	logFieldStack              = "stack"
+	logFieldPayload            = "payload"
+)
+
+// msgLogger writes the log messages.
+type msgLogger interface {
+	Debugf(msg string, args ...interface{})
+	Infof(msg string, args ...interface{})
+	Warnf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+type logField struct {
+	key   string
+	value string
+}
+
+// logFields are key/value pairs appended to a log message as key=value, so they can be filtered on once the logs
+// are aggregated.
+type logFields []logField
+
+// msgLogFields returns the correlation fields of the didcomm message.
+func msgLogFields(msg message.Msg) logFields {
+	fields := logFields{
+		{key: logFieldMsgType, value: msg.DIDCommMsg.Type()},
+		{key: logFieldMsgID, value: msg.DIDCommMsg.ID()},
+	}
+
+	if pthid := msg.DIDCommMsg.ParentThreadID(); pthid != "" {
+		fields = fields.with(logFieldParentThreadID, pthid)
+	}
+
+	return fields
+}
+
+// with returns a copy of the fields with the key/value pair appended.
+func (f logFields) with(key, value string) logFields {
+	return append(f[:len(f):len(f)], logField{key: key, value: value})
+}
+
+// withErr returns a copy of the fields with the error appended.
+func (f logFields) withErr(err error) logFields {
+	return f.with(logFieldError, err.Error())
+}
+
+func (f logFields) String() string {
+	s := make([]string, len(f))
+
+	for i, field := range f {
+		value := field.value
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+
+		s[i] = field.key + "=" + value
+	}
+
+	return strings.Join(s, " ")
+}
+
+func (f logFields) format(msg string, args ...interface{}) string {
+	if len(f) == 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+
+	return fmt.Sprintf(msg, args...) + " " + f.String()
+}
+
+func (f logFields) debugf(msg string, args ...interface{}) {
+	logger.Debugf("%s", f.format(msg, args...))
+}
+
+func (f logFields) infof(msg string, args ...interface{}) {
+	logger.Infof("%s", f.format(msg, args...))
+}
+
+func (f logFields) warnf(msg string, args ...interface{}) {
+	logger.Warnf("%s", f.format(msg, args...))
+}
+
+func (f logFields) errorf(msg string, args ...interface{}) {
+	logger.Errorf("%s", f.format(msg, args...))
+}
+
+// logPayload logs the message JSON at debug level if Config.DebugLogPayloads is set, passed through
+// Config.RedactPayload first if set.
+func (o *Service) logPayload(fields logFields, msg service.DIDCommMsg, name string) {
+	if !o.debugLogPayloads {
+		return
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fields.withErr(err).debugf("marshal %s", name)
+
+		return
+	}
+
+	if o.redactPayload != nil {
+		// the redactor gets a deep copy, it can't change the message that is handled or sent
+		payload := service.DIDCommMsgMap{}
+
+		err = json.Unmarshal(b, &payload)
+		if err == nil {
+			err = callHook("payload redactor", func() { payload = o.redactPayload(payload) })
+		}
+
+		if err == nil {
+			b, err = json.Marshal(payload)
+		}
+
+		if err != nil {
+			fields.withErr(err).debugf("redact %s", name)
+
+			return
+		}
+	}
+
+	fields.with(logFieldPayload, string(b)).debugf("%s", name)
+}
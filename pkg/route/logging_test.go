@@ -0,0 +1,206 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+func TestLogFields(t *testing.T) {
+	t.Parallel()
+
+	t.Run("message fields", func(t *testing.T) {
+		t.Parallel()
+
+		fields := msgLogFields(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   "msg-1",
+			Type: DIDDocReqMsgType,
+		})})
+		require.Equal(t, "msg_type="+DIDDocReqMsgType+" msg_id=msg-1", fields.String())
+
+		fields = msgLogFields(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     "msg-2",
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: "txn-1"},
+		})})
+		require.Equal(t, "msg_type="+RegisterRouteReqMsgType+" msg_id=msg-2 parent_thread_id=txn-1", fields.String())
+	})
+
+	t.Run("values are quoted when needed", func(t *testing.T) {
+		t.Parallel()
+
+		fields := logFields{}.with("a", "").with("b", "two words").with("c", `say "hi"`).with("d", "k=v")
+		require.Equal(t, `a="" b="two words" c="say \"hi\"" d="k=v"`, fields.String())
+	})
+
+	t.Run("with does not modify the receiver", func(t *testing.T) {
+		t.Parallel()
+
+		base := make(logFields, 0, 4).with(logFieldMsgID, "msg-1")
+
+		first := base.with(logFieldConnectionID, "conn-1")
+		second := base.withErr(errors.New("failed"))
+
+		require.Equal(t, "msg_id=msg-1", base.String())
+		require.Equal(t, "msg_id=msg-1 connection_id=conn-1", first.String())
+		require.Equal(t, "msg_id=msg-1 error=failed", second.String())
+	})
+
+	t.Run("format", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, "retrying in 1s", logFields{}.format("retrying in %s", "1s"))
+		require.Equal(t, "send reply error=failed", logFields{}.withErr(errors.New("failed")).format("send reply"))
+	})
+}
+
+// TestErrorSanitizer replaces the package logger, it is not parallel and runs before any test that may still be
+// logging.
+func TestErrorSanitizer(t *testing.T) { // nolint:paralleltest // replaces the package logger
+	recorder := &recordingLogger{}
+
+	defer func(l msgLogger) { logger = l }(logger)
+
+	logger = recorder
+
+	for _, problemReports := range []bool{false, true} {
+		replies := make(chan service.DIDCommMsgMap, 1)
+
+		config := config()
+		config.UseProblemReports = problemReports
+		config.ErrorSanitizer = func(err error) string {
+			return "request failed (" + errorCode(err) + ")"
+		}
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrGet: errors.New("leveldb /var/lib/adapter/txn: corrupted")}
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		reply := <-replies
+
+		if problemReports {
+			report := &ProblemReport{}
+			require.NoError(t, reply.Decode(report))
+			require.Equal(t, "request failed ("+ErrCodeTxnFetch+")", report.Description.Message)
+		} else {
+			errResp := &ErrorResp{}
+			require.NoError(t, reply.Decode(errResp))
+			require.Equal(t, "request failed ("+ErrCodeTxnFetch+")", errResp.Data.ErrorMsg)
+			require.Equal(t, ErrCodeTxnFetch, errResp.Data.Code)
+		}
+
+		require.NotContains(t, fmt.Sprint(reply), "corrupted")
+		require.NoError(t, c.Close(context.Background()))
+	}
+
+	var handled []string
+
+	for _, l := range recorder.errorLogs() {
+		if strings.Contains(l, "handle message") {
+			handled = append(handled, l)
+		}
+	}
+
+	require.Len(t, handled, 2)
+
+	for _, l := range handled {
+		require.Contains(t, l, "leveldb /var/lib/adapter/txn: corrupted")
+	}
+}
+
+func TestDebugLogPayloads(t *testing.T) { // nolint:paralleltest // replaces the package logger
+	defer func(l msgLogger) { logger = l }(logger)
+
+	// payloadLogs handles a diddoc-req and returns the payload debug logs.
+	payloadLogs := func(t *testing.T, enabled bool, redact func(service.DIDCommMsgMap) service.DIDCommMsgMap) []string {
+		t.Helper()
+
+		recorder := &recordingLogger{}
+		logger = recorder
+
+		config := config()
+		config.DebugLogPayloads = enabled
+		config.RedactPayload = redact
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   "diddoc-req-1",
+			Type: DIDDocReqMsgType,
+		})})
+
+		require.NoError(t, c.Close(context.Background()))
+
+		var payloads []string
+
+		for _, l := range recorder.debugLogs() {
+			if strings.Contains(l, logFieldPayload+"=") {
+				payloads = append(payloads, l)
+			}
+		}
+
+		return payloads
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		require.Empty(t, payloadLogs(t, false, nil))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		logs := payloadLogs(t, true, nil)
+		require.Len(t, logs, 2)
+
+		require.True(t, strings.HasPrefix(logs[0], "inbound payload "), logs[0])
+		require.Contains(t, logs[0], "diddoc-req-1")
+
+		require.True(t, strings.HasPrefix(logs[1], "reply payload "), logs[1])
+		require.Contains(t, logs[1], DIDDocRespMsgType)
+		require.Contains(t, logs[1], "did:local:abc")
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		logs := payloadLogs(t, true, func(payload service.DIDCommMsgMap) service.DIDCommMsgMap {
+			if data, ok := payload["data"].(map[string]interface{}); ok {
+				data["did"] = "[redacted]"
+				data["didDoc"] = "[redacted]"
+			}
+
+			return payload
+		})
+		require.Len(t, logs, 2)
+
+		require.Contains(t, logs[1], "[redacted]")
+		require.NotContains(t, logs[1], "did:local:abc")
+	})
+}
@@ -0,0 +1,50 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"fmt"
+
+	mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
+)
+
+// AriesMediatorClient is the mediator client of the aries framework.
+type AriesMediatorClient interface {
+	Register(connectionID string) error
+	Unregister(connectionID string) error
+	GetConnections(options ...mediatorsvc.ConnectionOption) ([]string, error)
+	GetConfig(connID string) (*mediatorsvc.Config, error)
+}
+
+// ariesMediator is the Mediator of an aries mediator client.
+type ariesMediator struct {
+	AriesMediatorClient
+}
+
+// NewMediator returns the Mediator of the aries mediator client. Its Config is the one of the router the adapter
+// is registered with, the first one if there are several, or no routing keys and endpoint if there is none.
+func NewMediator(client AriesMediatorClient) Mediator {
+	return &ariesMediator{AriesMediatorClient: client}
+}
+
+func (m *ariesMediator) Config() ([]string, string, error) {
+	connIDs, err := m.GetConnections()
+	if err != nil {
+		return nil, "", fmt.Errorf("get router connections : %w", err)
+	}
+
+	if len(connIDs) == 0 {
+		return nil, "", nil
+	}
+
+	config, err := m.GetConfig(connIDs[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("get router config : %w", err)
+	}
+
+	return config.Keys(), config.Endpoint(), nil
+}
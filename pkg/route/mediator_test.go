@@ -0,0 +1,75 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"errors"
+	"testing"
+
+	mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
+	"github.com/stretchr/testify/require"
+
+	mockmediator "github.com/trustbloc/edge-adapter/pkg/internal/mock/mediator"
+)
+
+type mockAriesMediator struct {
+	mockmediator.MockClient
+	connIDs []string
+	connErr error
+}
+
+func (m *mockAriesMediator) GetConnections(...mediatorsvc.ConnectionOption) ([]string, error) {
+	return m.connIDs, m.connErr
+}
+
+func TestNewMediator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("config of the router", func(t *testing.T) {
+		t.Parallel()
+
+		client := &mockAriesMediator{connIDs: []string{"conn-1", "conn-2"}}
+		client.GetConfigFunc = func(connID string) (*mediatorsvc.Config, error) {
+			require.Equal(t, "conn-1", connID)
+
+			return mediatorsvc.NewConfig("https://mediator.example.com", []string{"did:key:z6MkRouting"}), nil
+		}
+
+		routingKeys, endpoint, err := NewMediator(client).Config()
+		require.NoError(t, err)
+		require.Equal(t, []string{"did:key:z6MkRouting"}, routingKeys)
+		require.Equal(t, "https://mediator.example.com", endpoint)
+	})
+
+	t.Run("no router", func(t *testing.T) {
+		t.Parallel()
+
+		routingKeys, endpoint, err := NewMediator(&mockAriesMediator{}).Config()
+		require.NoError(t, err)
+		require.Empty(t, routingKeys)
+		require.Empty(t, endpoint)
+	})
+
+	t.Run("get connections error", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := NewMediator(&mockAriesMediator{connErr: errors.New("db error")}).Config()
+		require.EqualError(t, err, "get router connections : db error")
+	})
+
+	t.Run("get config error", func(t *testing.T) {
+		t.Parallel()
+
+		client := &mockAriesMediator{connIDs: []string{"conn-1"}}
+		client.GetConfigFunc = func(string) (*mediatorsvc.Config, error) {
+			return nil, errors.New("router offline")
+		}
+
+		_, _, err := NewMediator(client).Config()
+		require.EqualError(t, err, "get router config : router offline")
+	})
+}
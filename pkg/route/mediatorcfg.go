@@ -0,0 +1,91 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultMediatorConfigRefresh = 5 * time.Minute
+
+// mediatorConfigCache keeps the routing keys and endpoint of the mediator for the refresh interval, they rarely
+// change and are sent with every diddoc-resp. A failed fetch is not cached.
+type mediatorConfigCache struct {
+	source  Mediator
+	refresh time.Duration
+	clock   Clock
+	// concurrent requests on an expired entry share a single fetch
+	fetches singleflight.Group
+
+	mu          sync.Mutex
+	fetched     bool
+	fetchedAt   time.Time
+	routingKeys []string
+	endpoint    string
+}
+
+func newMediatorConfigCache(source Mediator, refresh time.Duration, clock Clock) *mediatorConfigCache {
+	if refresh <= 0 {
+		refresh = defaultMediatorConfigRefresh
+	}
+
+	return &mediatorConfigCache{source: source, refresh: refresh, clock: clock}
+}
+
+// get returns the cached config, fetching it if it is missing or older than the refresh interval. It waits for
+// the fetch until the context is done, the fetch keeps running for the next requests.
+func (c *mediatorConfigCache) get(ctx context.Context) ([]string, string, error) {
+	c.mu.Lock()
+	fresh := c.fetched && c.clock.Now().Sub(c.fetchedAt) < c.refresh
+	c.mu.Unlock()
+
+	if !fresh {
+		err := c.fetch(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.routingKeys...), c.endpoint, nil
+}
+
+// forceRefresh fetches the config, whatever its age, or joins the fetch in progress. On error the cached config is
+// kept.
+func (c *mediatorConfigCache) forceRefresh(ctx context.Context) error {
+	return c.fetch(ctx)
+}
+
+func (c *mediatorConfigCache) fetch(ctx context.Context) error {
+	resCh := c.fetches.DoChan("", func() (interface{}, error) {
+		routingKeys, endpoint, err := c.source.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.routingKeys, c.endpoint = routingKeys, endpoint
+		c.fetched, c.fetchedAt = true, c.clock.Now()
+
+		return nil, nil
+	})
+
+	select {
+	case res := <-resCh:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	mockmediator "github.com/trustbloc/edge-adapter/pkg/internal/mock/mediator"
+)
+
+func TestMediatorConfigCache(t *testing.T) {
+	t.Parallel()
+
+	newService := func(t *testing.T) (*Service, *mockMediatorConfig, *fakeClock) {
+		t.Helper()
+
+		mediator := &mockMediatorConfig{
+			routingKeys: []string{"did:key:z6MkRouting#z6MkRouting"},
+			endpoint:    "https://mediator.example.com",
+		}
+		clock := &fakeClock{now: time.Now()}
+
+		config := config()
+		config.MediatorClient = mediator
+		config.MediatorConfigRefresh = time.Minute
+		config.Clock = clock
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { require.NoError(t, c.Close(context.Background())) })
+
+		return c, mediator, clock
+	}
+
+	// routingEndpoint runs a diddoc-req and returns the mediator endpoint of its diddoc-resp.
+	routingEndpoint := func(t *testing.T, c *Service) (string, error) {
+		t.Helper()
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		if err != nil {
+			return "", err
+		}
+
+		resp := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(resp))
+		require.Equal(t, []string{"did:key:z6MkRouting#z6MkRouting"}, resp.Data.RoutingKeys)
+
+		return resp.Data.RoutingEndpoint, nil
+	}
+
+	t.Run("fetched once within the refresh interval", func(t *testing.T) {
+		t.Parallel()
+
+		c, mediator, clock := newService(t)
+
+		for i := 0; i < 3; i++ {
+			endpoint, err := routingEndpoint(t, c)
+			require.NoError(t, err)
+			require.Equal(t, "https://mediator.example.com", endpoint)
+
+			clock.advance(10 * time.Second)
+		}
+
+		require.Equal(t, 1, mediator.configCalls())
+
+		mediator.set("https://mediator2.example.com", nil)
+		clock.advance(time.Minute)
+
+		endpoint, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+		require.Equal(t, "https://mediator2.example.com", endpoint)
+		require.Equal(t, 2, mediator.configCalls())
+	})
+
+	t.Run("forced refresh", func(t *testing.T) {
+		t.Parallel()
+
+		c, mediator, _ := newService(t)
+
+		_, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+
+		mediator.set("https://mediator2.example.com", nil)
+
+		require.NoError(t, c.RefreshMediatorConfig(context.Background()))
+
+		endpoint, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+		require.Equal(t, "https://mediator2.example.com", endpoint)
+		require.Equal(t, 2, mediator.configCalls())
+	})
+
+	t.Run("failed refresh keeps the cached config", func(t *testing.T) {
+		t.Parallel()
+
+		c, mediator, _ := newService(t)
+
+		_, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+
+		mediator.set("", errors.New("mediator offline"))
+
+		err = c.RefreshMediatorConfig(context.Background())
+		require.EqualError(t, err, "refresh mediator config : mediator offline")
+
+		endpoint, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+		require.Equal(t, "https://mediator.example.com", endpoint)
+	})
+
+	t.Run("failed fetch is not cached", func(t *testing.T) {
+		t.Parallel()
+
+		c, mediator, _ := newService(t)
+
+		mediator.set("https://mediator.example.com", errors.New("mediator offline"))
+
+		_, err := routingEndpoint(t, c)
+		require.EqualError(t, err, "get mediator config : mediator offline")
+
+		mediator.set("https://mediator.example.com", nil)
+
+		endpoint, err := routingEndpoint(t, c)
+		require.NoError(t, err)
+		require.Equal(t, "https://mediator.example.com", endpoint)
+		require.Equal(t, 2, mediator.configCalls())
+	})
+
+	t.Run("aries mediator client", func(t *testing.T) {
+		t.Parallel()
+
+		var getConfigCalls int32
+
+		client := &mockAriesMediator{connIDs: []string{"conn-1"}}
+		client.GetConfigFunc = func(string) (*mediatorsvc.Config, error) {
+			atomic.AddInt32(&getConfigCalls, 1)
+
+			return mediatorsvc.NewConfig("https://mediator.example.com",
+				[]string{"did:key:z6MkRouting#z6MkRouting"}), nil
+		}
+
+		config := config()
+		config.MediatorClient = NewMediator(client)
+		config.MediatorConfigRefresh = time.Minute
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		for i := 0; i < 3; i++ {
+			endpoint, err := routingEndpoint(t, c)
+			require.NoError(t, err)
+			require.Equal(t, "https://mediator.example.com", endpoint)
+		}
+
+		require.EqualValues(t, 1, atomic.LoadInt32(&getConfigCalls))
+
+		require.NoError(t, c.RefreshMediatorConfig(context.Background()))
+		require.EqualValues(t, 2, atomic.LoadInt32(&getConfigCalls))
+	})
+
+	t.Run("mediator without router", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		require.NoError(t, c.RefreshMediatorConfig(context.Background()))
+	})
+
+	t.Run("hung mediator", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		config := config()
+		config.MediatorClient = &mockmediator.MockClient{ConfigFunc: func() ([]string, string, error) {
+			<-release
+
+			return nil, "", nil
+		}}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = c.RefreshMediatorConfig(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
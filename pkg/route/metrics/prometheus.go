@@ -0,0 +1,76 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "adapter"
+	subsystem = "blinded_routing"
+	msgType   = "msg_type"
+)
+
+// Prometheus records the blinded routing message metrics with prometheus.
+type Prometheus struct {
+	received *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewPrometheus returns a new Prometheus with its collectors registered with the given registerer.
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	p := &Prometheus{
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_received_total",
+			Help:      "Number of blinded routing messages received.",
+		}, []string{msgType}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "message_errors_total",
+			Help:      "Number of blinded routing messages that failed to be handled.",
+		}, []string{msgType}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "handler_duration_seconds",
+			Help:      "Time taken to handle a blinded routing message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{msgType}),
+	}
+
+	for _, c := range []prometheus.Collector{p.received, p.errors, p.duration} {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf("register collector : %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// IncMessageReceived increments the received messages counter for the message type.
+func (p *Prometheus) IncMessageReceived(t string) {
+	p.received.WithLabelValues(t).Inc()
+}
+
+// IncMessageError increments the failed messages counter for the message type.
+func (p *Prometheus) IncMessageError(t string) {
+	p.errors.WithLabelValues(t).Inc()
+}
+
+// ObserveHandlerDuration records the time taken to handle a message of the message type.
+func (p *Prometheus) ObserveHandlerDuration(t string, d time.Duration) {
+	p.duration.WithLabelValues(t).Observe(d.Seconds())
+}
@@ -0,0 +1,64 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testMsgType = "https://trustbloc.dev/blinded-routing/1.0/diddoc-req"
+
+func TestNewPrometheus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := NewPrometheus(prometheus.NewRegistry())
+		require.NoError(t, err)
+		require.NotNil(t, p)
+	})
+
+	t.Run("error registering collectors twice", func(t *testing.T) {
+		t.Parallel()
+
+		reg := prometheus.NewRegistry()
+
+		_, err := NewPrometheus(reg)
+		require.NoError(t, err)
+
+		_, err = NewPrometheus(reg)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "register collector")
+	})
+}
+
+func TestPrometheus(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+
+	p, err := NewPrometheus(reg)
+	require.NoError(t, err)
+
+	p.IncMessageReceived(testMsgType)
+	p.IncMessageReceived(testMsgType)
+	p.IncMessageError(testMsgType)
+	p.ObserveHandlerDuration(testMsgType, time.Second)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(p.received.WithLabelValues(testMsgType)))
+	require.Equal(t, float64(1), testutil.ToFloat64(p.errors.WithLabelValues(testMsgType)))
+
+	count, err := testutil.GatherAndCount(reg, "adapter_blinded_routing_handler_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
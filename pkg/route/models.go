@@ -25,10 +25,14 @@ type DIDDocResp struct {
 	Data *DIDDocRespData `json:"data,omitempty"`
 }
 
-// DIDDocRespData model for error data in DIDDocResp.
+// DIDDocRespData model for error data in DIDDocResp. DID is the id of DIDDoc. RoutingKeys and RoutingEndpoint are
+// the ones of the mediator, if known.
 type DIDDocRespData struct {
-	ErrorMsg string          `json:"errorMsg,omitempty"`
-	DIDDoc   json.RawMessage `json:"didDoc,omitempty"`
+	ErrorMsg        string          `json:"errorMsg,omitempty"`
+	DID             string          `json:"did,omitempty"`
+	DIDDoc          json.RawMessage `json:"didDoc,omitempty"`
+	RoutingKeys     []string        `json:"routingKeys,omitempty"`
+	RoutingEndpoint string          `json:"routingEndpoint,omitempty"`
 }
 
 // ConnReq model.
@@ -39,17 +43,70 @@ type ConnReq struct {
 	Data   *ConnReqData      `json:"data,omitempty"`
 }
 
-// ConnReqData model for error data in ConnReq.
+// ConnReqData model for error data in ConnReq. With DryRun the request is only validated, no connection is
+// created and no route registered. Label is the label of the connection created for the client. EndpointHint and
+// Protocols order the routing endpoints of the response: the hinted one first if it is one of them, then the ones
+// whose URL scheme is in Protocols, eg. "wss", in the order given. TTL is the requested lifetime of the route in
+// seconds, the route is unregistered once it expires. Without it the route doesn't expire, even if it was
+// registered with one before. All but DIDDoc are optional, unknown fields are ignored.
 type ConnReqData struct {
-	DIDDoc json.RawMessage `json:"didDoc,omitempty"`
+	DIDDoc       json.RawMessage `json:"didDoc,omitempty"`
+	DryRun       bool            `json:"dryRun,omitempty"`
+	Label        string          `json:"label,omitempty"`
+	EndpointHint string          `json:"endpointHint,omitempty"`
+	Protocols    []string        `json:"protocols,omitempty"`
+	TTL          int64           `json:"ttl,omitempty"`
 }
 
 // ConnResp model.
 type ConnResp struct {
+	ID     string            `json:"@id,omitempty"`
+	Type   string            `json:"@type,omitempty"`
+	Thread *decorator.Thread `json:"~thread,omitempty"`
+	Data   *ConnRespData     `json:"data,omitempty"`
+}
+
+// ConnRespData model for the registered route in ConnResp. The response to a dry run has DryRun set and no
+// ConnectionID.
+type ConnRespData struct {
+	ConnectionID     string   `json:"connectionID,omitempty"`
+	RoutingEndpoints []string `json:"routingEndpoints,omitempty"`
+	DryRun           bool     `json:"dryRun,omitempty"`
+}
+
+// UnregisterRouteReq model. It is sent on the connection the route was registered on.
+type UnregisterRouteReq struct {
 	ID   string `json:"@id,omitempty"`
 	Type string `json:"@type,omitempty"`
 }
 
+// UnregisterRouteResp model.
+type UnregisterRouteResp struct {
+	ID     string                   `json:"@id,omitempty"`
+	Type   string                   `json:"@type,omitempty"`
+	Thread *decorator.Thread        `json:"~thread,omitempty"`
+	Data   *UnregisterRouteRespData `json:"data,omitempty"`
+}
+
+// UnregisterRouteRespData model for the route torn down in UnregisterRouteResp.
+type UnregisterRouteRespData struct {
+	ConnectionID string `json:"connectionID,omitempty"`
+}
+
+// Ping model.
+type Ping struct {
+	ID   string `json:"@id,omitempty"`
+	Type string `json:"@type,omitempty"`
+}
+
+// PingResp model. Timestamp is the RFC 3339 time the ping was handled at.
+type PingResp struct {
+	ID        string            `json:"@id,omitempty"`
+	Type      string            `json:"@type,omitempty"`
+	Thread    *decorator.Thread `json:"~thread,omitempty"`
+	Timestamp string            `json:"timestamp,omitempty"`
+}
+
 // ErrorResp model.
 type ErrorResp struct {
 	ID   string         `json:"@id,omitempty"`
@@ -57,7 +114,22 @@ type ErrorResp struct {
 	Data *ErrorRespData `json:"data,omitempty"`
 }
 
-// ErrorRespData model for error data in ErrorResp.
+// ErrorRespData model for error data in ErrorResp. Code is one of the ErrCode constants.
 type ErrorRespData struct {
+	Code     string `json:"code,omitempty"`
 	ErrorMsg string `json:"errorMsg,omitempty"`
 }
+
+// ProblemReport model as defined in Aries RFC 0035.
+type ProblemReport struct {
+	ID          string                    `json:"@id,omitempty"`
+	Type        string                    `json:"@type,omitempty"`
+	Thread      *decorator.Thread         `json:"~thread,omitempty"`
+	Description *ProblemReportDescription `json:"description,omitempty"`
+}
+
+// ProblemReportDescription model for the description in ProblemReport.
+type ProblemReportDescription struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"en,omitempty"`
+}
@@ -0,0 +1,122 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDIDDocResp(t *testing.T) {
+	t.Parallel()
+
+	didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+
+	docBytes, err := didDoc.JSONBytes()
+	require.NoError(t, err)
+
+	respBytes, err := json.Marshal(&DIDDocResp{
+		ID:   "resp-1",
+		Type: DIDDocRespMsgType,
+		Data: &DIDDocRespData{DID: didDoc.ID, DIDDoc: docBytes},
+	})
+	require.NoError(t, err)
+
+	msg, err := service.ParseDIDCommMsgMap(respBytes)
+	require.NoError(t, err)
+
+	decoded := &DIDDocResp{}
+	require.NoError(t, msg.Decode(decoded))
+
+	doc, err := did.ParseDocument(decoded.Data.DIDDoc)
+	require.NoError(t, err)
+	require.Equal(t, doc.ID, decoded.Data.DID)
+}
+
+func TestConnReqData(t *testing.T) {
+	t.Parallel()
+
+	decode := func(t *testing.T, payload string) *ConnReq {
+		t.Helper()
+
+		msg, err := service.ParseDIDCommMsgMap([]byte(payload))
+		require.NoError(t, err)
+
+		req := &ConnReq{}
+		require.NoError(t, msg.Decode(req))
+
+		return req
+	}
+
+	t.Run("extended", func(t *testing.T) {
+		t.Parallel()
+
+		req := decode(t, `{"@id":"req-1","@type":"`+RegisterRouteReqMsgType+`","data":{"didDoc":{"id":"did:peer:1"},`+
+			`"label":"wallet","endpointHint":"wss://adapter.com","protocols":["wss","https"],"ttl":3600}}`)
+		require.Equal(t, &ConnReqData{
+			DIDDoc:       json.RawMessage(`{"id":"did:peer:1"}`),
+			Label:        "wallet",
+			EndpointHint: "wss://adapter.com",
+			Protocols:    []string{"wss", "https"},
+			TTL:          3600,
+		}, req.Data)
+	})
+
+	t.Run("did doc only", func(t *testing.T) {
+		t.Parallel()
+
+		req := decode(t, `{"@id":"req-1","@type":"`+RegisterRouteReqMsgType+`","data":{"didDoc":{"id":"did:peer:1"}}}`)
+		require.Equal(t, &ConnReqData{DIDDoc: json.RawMessage(`{"id":"did:peer:1"}`)}, req.Data)
+	})
+
+	t.Run("unknown fields ignored", func(t *testing.T) {
+		t.Parallel()
+
+		req := decode(t, `{"@id":"req-1","@type":"`+RegisterRouteReqMsgType+`","data":{"didDoc":{"id":"did:peer:1"},`+
+			`"priority":1,"transport":{"mode":"push"}}}`)
+		require.Equal(t, &ConnReqData{DIDDoc: json.RawMessage(`{"id":"did:peer:1"}`)}, req.Data)
+	})
+}
+
+func TestConnResp(t *testing.T) {
+	t.Parallel()
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		resp := &ConnResp{
+			ID:   "resp-1",
+			Type: RegisterRouteRespMsgType,
+			Thread: &decorator.Thread{
+				ID:  "thread-1",
+				PID: "txn-1",
+			},
+			Data: &ConnRespData{
+				ConnectionID:     "conn-1",
+				RoutingEndpoints: []string{"http://adapter.com", "ws://adapter.com"},
+			},
+		}
+
+		decoded := &ConnResp{}
+		require.NoError(t, service.NewDIDCommMsgMap(resp).Decode(decoded))
+		require.Equal(t, resp, decoded)
+	})
+
+	t.Run("decode response without data", func(t *testing.T) {
+		t.Parallel()
+
+		decoded := &ConnResp{}
+		require.NoError(t, json.Unmarshal([]byte(`{"@id":"resp-1","@type":"`+RegisterRouteRespMsgType+`"}`), decoded))
+		require.Equal(t, &ConnResp{ID: "resp-1", Type: RegisterRouteRespMsgType}, decoded)
+	})
+}
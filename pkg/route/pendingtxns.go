@@ -0,0 +1,93 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pendingTxns tracks the keys of the diddoc-req transactions in the txn store, oldest first, so MaxPendingTxns is
+// enforced without scanning the store on every diddoc-req. It is loaded from the store once, then kept up to date
+// as the txns are saved and deleted.
+type pendingTxns struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newPendingTxns() *pendingTxns {
+	return &pendingTxns{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// isPendingTxn reports whether the txn store entry is a diddoc-req transaction rather than a marker.
+func isPendingTxn(key string) bool {
+	return !strings.HasPrefix(key, registeredKeyPrefix) && !strings.HasPrefix(key, routerDIDKeyPrefix)
+}
+
+// load adds the pending txns among the stored ones, in their creation order.
+func (p *pendingTxns) load(txns []storedTxn) {
+	sort.SliceStable(txns, func(i, j int) bool {
+		return txns[i].created.Before(txns[j].created)
+	})
+
+	for _, txn := range txns {
+		if isPendingTxn(txn.key) {
+			p.add(txn.key)
+		}
+	}
+}
+
+// add tracks the txn as the newest one.
+func (p *pendingTxns) add(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[key]; ok {
+		p.order.MoveToBack(e)
+
+		return
+	}
+
+	p.entries[key] = p.order.PushBack(key)
+}
+
+func (p *pendingTxns) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[key]; ok {
+		p.order.Remove(e)
+		delete(p.entries, key)
+	}
+}
+
+func (p *pendingTxns) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.order.Len()
+}
+
+// oldest returns the keys of the n oldest txns.
+func (p *pendingTxns) oldest(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]string, 0, n)
+
+	for e := p.order.Front(); e != nil && len(keys) < n; e = e.Next() {
+		keys = append(keys, e.Value.(string))
+	}
+
+	return keys
+}
@@ -0,0 +1,44 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"errors"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/verifier"
+)
+
+// DIDDocProofVerifier verifies the proof embedded in a did doc.
+type DIDDocProofVerifier interface {
+	VerifyProof(doc *did.Doc) error
+}
+
+// NewDIDDocProofVerifier returns a DIDDocProofVerifier checking the proofs with the signature suites against the
+// verification methods of the doc itself. The JSON-LD options should set a document loader.
+func NewDIDDocProofVerifier(suites []verifier.SignatureSuite, opts ...jsonld.ProcessorOpts) DIDDocProofVerifier {
+	return &suiteProofVerifier{suites: suites, opts: opts}
+}
+
+type suiteProofVerifier struct {
+	suites []verifier.SignatureSuite
+	opts   []jsonld.ProcessorOpts
+}
+
+func (v *suiteProofVerifier) VerifyProof(doc *did.Doc) error {
+	return doc.VerifyProof(v.suites, v.opts...) // nolint:wrapcheck // wrapped by the caller
+}
+
+// verifyDIDDocProof rejects unsigned docs before handing the signed ones to the verifier.
+func verifyDIDDocProof(v DIDDocProofVerifier, doc *did.Doc) error {
+	if len(doc.Proof) == 0 {
+		return errors.New("did doc is not signed")
+	}
+
+	return v.VerifyProof(doc)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/common/model"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/signer"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite/ed25519signature2018"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/verifier"
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	"github.com/trustbloc/edge-adapter/pkg/internal/testutil"
+)
+
+func TestVerifyDIDDocProof(t *testing.T) {
+	t.Parallel()
+
+	t.Run("verifier required", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.VerifyDIDDocProof = true
+
+		_, err := New(config)
+		require.EqualError(t, err, "verify did doc proof : did doc proof verifier required")
+	})
+
+	signed := signedDIDDoc(t)
+
+	for name, tc := range map[string]struct {
+		doc    []byte
+		verify bool
+		errMsg string
+	}{
+		"signed doc": {
+			doc:    signed,
+			verify: true,
+		},
+		"unsigned doc": {
+			doc:    unsignedDIDDoc(t),
+			verify: true,
+			errMsg: "verify did doc proof : did doc is not signed",
+		},
+		"tampered doc": {
+			doc:    bytes.Replace(signed, []byte(`"id":"did:peer:`), []byte(`"id":"did:peer:mallory`), 1),
+			verify: true,
+			errMsg: "verify did doc proof : ed25519: invalid signature",
+		},
+		"unsigned doc without verification": {
+			doc: unsignedDIDDoc(t),
+		},
+	} {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config := config()
+			config.VerifyDIDDocProof = tc.verify
+			config.DIDDocProofVerifier = NewDIDDocProofVerifier([]verifier.SignatureSuite{
+				ed25519signature2018.New(suite.WithVerifier(ed25519signature2018.NewPublicKeyVerifier())),
+			}, jsonld.WithDocumentLoader(testutil.DocumentLoader(t)))
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			txnID := uuid.New().String()
+
+			err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+			require.NoError(t, err)
+
+			_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: tc.doc},
+			})})
+			if tc.errMsg == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.errMsg)
+			require.Equal(t, ErrCodeDIDDocUnverified, errorCode(err))
+		})
+	}
+}
+
+// unsignedDIDDoc returns a client did doc with an ed25519 verification method for signing it.
+func unsignedDIDDoc(t *testing.T) []byte {
+	t.Helper()
+
+	docBytes, _ := clientDIDDoc(t)
+
+	return docBytes
+}
+
+// signedDIDDoc returns a client did doc with an Ed25519Signature2018 proof.
+func signedDIDDoc(t *testing.T) []byte {
+	t.Helper()
+
+	docBytes, privKey := clientDIDDoc(t)
+
+	doc, err := did.ParseDocument(docBytes)
+	require.NoError(t, err)
+
+	s := signer.New(ed25519signature2018.New(suite.WithSigner(&ed25519Signer{privKey: privKey})))
+
+	signed, err := s.Sign(&signer.Context{
+		Creator:       doc.VerificationMethod[0].ID,
+		SignatureType: ed25519signature2018.SignatureType,
+	}, docBytes, jsonld.WithDocumentLoader(testutil.DocumentLoader(t)))
+	require.NoError(t, err)
+
+	return signed
+}
+
+func clientDIDDoc(t *testing.T) ([]byte, ed25519.PrivateKey) {
+	t.Helper()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	doc := mockdiddoc.GetMockDIDDoc(t, false)
+	doc.Context = []string{did.ContextV1Old, "https://w3id.org/security/v1"}
+	doc.VerificationMethod = []did.VerificationMethod{
+		*did.NewVerificationMethodFromBytes(doc.ID+"#key-1", "Ed25519VerificationKey2018", doc.ID, pubKey),
+	}
+	doc.Service[0].ServiceEndpoint = model.NewDIDCommV1Endpoint("http://client.example.com")
+
+	docBytes, err := doc.JSONBytes()
+	require.NoError(t, err)
+
+	return docBytes, privKey
+}
+
+type ed25519Signer struct {
+	privKey ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privKey, data), nil
+}
+
+func (s *ed25519Signer) Alg() string {
+	return ""
+}
@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxRateLimitBuckets is the number of sender buckets above which the idle ones are dropped.
+const maxRateLimitBuckets = 10000
+
+// RateLimit configures the token bucket limiting the messages handled per sender.
+type RateLimit struct {
+	// Rate is the number of messages per second a sender can sustain. Zero or less disables the rate limit.
+	Rate float64
+	// Burst is the number of messages a sender can send at once. Values lower than 1 mean 1.
+	Burst int
+}
+
+// tokenBucket holds the tokens left at the last update.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter limits the messages per sender with a token bucket each. Senders that can't be identified share
+// a global bucket. A nil rateLimiter allows everything.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	clock   Clock
+	buckets map[string]*tokenBucket
+	global  *tokenBucket
+}
+
+func newRateLimiter(limit RateLimit, clock Clock) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	burst := math.Max(float64(limit.Burst), 1)
+
+	return &rateLimiter{
+		rate:    limit.Rate,
+		burst:   burst,
+		clock:   clock,
+		buckets: map[string]*tokenBucket{},
+		global:  &tokenBucket{tokens: burst, updated: clock.Now()},
+	}
+}
+
+// allow takes a token from the sender's bucket, it reports false if there is none left.
+func (l *rateLimiter) allow(sender string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	b := l.global
+
+	if sender != "" {
+		var ok bool
+
+		b, ok = l.buckets[sender]
+		if !ok {
+			if len(l.buckets) >= maxRateLimitBuckets {
+				l.dropIdle(now)
+			}
+
+			b = &tokenBucket{tokens: l.burst, updated: now}
+			l.buckets[sender] = b
+		}
+	}
+
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+	}
+
+	b.updated = now
+}
+
+// dropIdle removes the buckets that are full again, a new bucket for the sender is the same.
+func (l *rateLimiter) dropIdle(now time.Time) {
+	for sender, b := range l.buckets {
+		l.refill(b, now)
+
+		if b.tokens >= l.burst {
+			delete(l.buckets, sender)
+		}
+	}
+}
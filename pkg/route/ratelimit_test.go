@@ -0,0 +1,121 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		l := newRateLimiter(RateLimit{}, realClock{})
+		require.Nil(t, l)
+
+		for i := 0; i < 100; i++ {
+			require.True(t, l.allow("did:example:sender"))
+		}
+	})
+
+	t.Run("throttles senders above the rate", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Now()}
+		l := newRateLimiter(RateLimit{Rate: 1, Burst: 2}, clock)
+
+		require.True(t, l.allow("did:example:a"))
+		require.True(t, l.allow("did:example:a"))
+		require.False(t, l.allow("did:example:a"))
+
+		// other senders have their own bucket
+		require.True(t, l.allow("did:example:b"))
+
+		clock.advance(500 * time.Millisecond)
+		require.False(t, l.allow("did:example:a"))
+
+		clock.advance(500 * time.Millisecond)
+		require.True(t, l.allow("did:example:a"))
+		require.False(t, l.allow("did:example:a"))
+
+		// the bucket doesn't fill above the burst
+		clock.advance(time.Hour)
+		require.True(t, l.allow("did:example:a"))
+		require.True(t, l.allow("did:example:a"))
+		require.False(t, l.allow("did:example:a"))
+	})
+
+	t.Run("unidentified senders share the global bucket", func(t *testing.T) {
+		t.Parallel()
+
+		l := newRateLimiter(RateLimit{Rate: 1}, &fakeClock{now: time.Now()})
+
+		require.True(t, l.allow(""))
+		require.False(t, l.allow(""))
+		require.True(t, l.allow("did:example:a"))
+	})
+
+	t.Run("drops idle buckets", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Now()}
+		l := newRateLimiter(RateLimit{Rate: 1}, clock)
+
+		for i := 0; i < maxRateLimitBuckets; i++ {
+			require.True(t, l.allow(uuid.New().String()))
+		}
+
+		clock.advance(time.Second)
+		require.True(t, l.allow("did:example:a"))
+		require.Len(t, l.buckets, 1)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	config := config()
+	config.RateLimit = RateLimit{Rate: 0.001, Burst: 2}
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	ping := func(sender string) error {
+		_, err := c.callHandler(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType}),
+			TheirDID:   sender,
+		})
+
+		return err
+	}
+
+	require.NoError(t, ping("did:example:a"))
+	require.NoError(t, ping("did:example:a"))
+
+	err = ping("did:example:a")
+	require.Error(t, err)
+	require.Equal(t, ErrCodeRateLimited, errorCode(err))
+	require.Contains(t, err.Error(), "rate limited")
+
+	require.NoError(t, ping("did:example:b"))
+
+	resp := c.errorResp(service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType}), err)
+
+	errResp := &ErrorResp{}
+	require.NoError(t, resp.Decode(errResp))
+	require.Equal(t, ErrCodeRateLimited, errResp.Data.Code)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how failed operations are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one. Values lower than 1 mean 1.
+	MaxAttempts int
+	// Backoff is the wait before the first retry. It doubles for every following retry.
+	Backoff time.Duration
+}
+
+// retry calls fn until it succeeds, returns an error that is not retryable, the attempts are exhausted, done
+// is closed or the context is done.
+func retry(ctx context.Context, policy RetryPolicy, done <-chan struct{}, retryable func(error) bool,
+	fn func() error) error {
+	backoff := policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		// no retry once the context is done, eg. fn failed with the context error
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if errors.Is(err, ctxErr) {
+				return err
+			}
+
+			return fmt.Errorf("retry aborted (%s) : %w", err.Error(), ctxErr)
+		}
+
+		logFields{}.with("attempt", strconv.Itoa(attempt)).withErr(err).debugf("retrying in %s", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-done:
+			return fmt.Errorf("retry aborted by shutdown : %w", err)
+		case <-ctx.Done():
+			return fmt.Errorf("retry aborted (%s) : %w", err.Error(), ctx.Err())
+		}
+
+		backoff *= 2
+	}
+}
+
+// withContext runs fn and waits until it returns or the context is done. In the latter case fn keeps running in
+// the background and its result is discarded. A panic in fn is returned as an error.
+func withContext(ctx context.Context, fn func() error) error {
+	_, err := withContextResult(ctx, func() (interface{}, error) {
+		return nil, fn()
+	})
+
+	return err
+}
+
+// withContextResult is withContext for an fn with a result. The result is handed over with the error: an fn
+// still running after the context is done must not write to the variables of the caller, which has moved on.
+func withContextResult(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+
+	resCh := make(chan result, 1)
+
+	go func() {
+		// a panic can't be recovered by the caller in this goroutine, hand it over
+		defer func() {
+			if r := recover(); r != nil {
+				resCh <- result{err: fmt.Errorf("panic : %v", r)}
+			}
+		}()
+
+		value, err := fn()
+		resCh <- result{value: value, err: err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
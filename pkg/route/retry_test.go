@@ -0,0 +1,228 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	always := func(error) bool { return true }
+
+	t.Run("success after failures", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		err := retry(context.Background(), RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, nil, always, func() error {
+			calls++
+
+			if calls < 3 {
+				return errors.New("transient")
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		expected := errors.New("transient")
+
+		err := retry(context.Background(), RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}, nil, always, func() error {
+			calls++
+
+			return expected
+		})
+		require.ErrorIs(t, err, expected)
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("no retry by default", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		err := retry(context.Background(), RetryPolicy{}, nil, always, func() error {
+			calls++
+
+			return errors.New("transient")
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		err := retry(context.Background(), RetryPolicy{MaxAttempts: 3}, nil, func(error) bool { return false }, func() error {
+			calls++
+
+			return errors.New("permanent")
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("aborted by shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		done := make(chan struct{})
+		close(done)
+
+		calls := 0
+		expected := errors.New("transient")
+
+		err := retry(context.Background(), RetryPolicy{MaxAttempts: 3, Backoff: time.Minute}, done, always, func() error {
+			calls++
+
+			return expected
+		})
+		require.ErrorIs(t, err, expected)
+		require.Contains(t, err.Error(), "retry aborted by shutdown")
+		require.Equal(t, 1, calls)
+	})
+	t.Run("aborted by context", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		calls := 0
+
+		err := retry(ctx, RetryPolicy{MaxAttempts: 3, Backoff: time.Minute}, nil, always, func() error {
+			calls++
+
+			return errors.New("transient")
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Contains(t, err.Error(), "retry aborted (transient)")
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("no retry once fn fails with the context error", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		calls := 0
+
+		err := retry(ctx, RetryPolicy{MaxAttempts: 3, Backoff: time.Nanosecond}, nil, always, func() error {
+			calls++
+
+			cancel()
+
+			return ctx.Err()
+		})
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestWithContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns the function result", func(t *testing.T) {
+		t.Parallel()
+
+		expected := errors.New("fn error")
+
+		require.NoError(t, withContext(context.Background(), func() error { return nil }))
+		require.ErrorIs(t, withContext(context.Background(), func() error { return expected }), expected)
+	})
+
+	t.Run("function panics", func(t *testing.T) {
+		t.Parallel()
+
+		err := withContext(context.Background(), func() error { panic("fn panic") })
+		require.EqualError(t, err, "panic : fn panic")
+	})
+
+	t.Run("context done before the function returns", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := withContext(ctx, func() error {
+			<-release
+
+			return nil
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("context already done", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		called := false
+
+		err := withContext(ctx, func() error {
+			called = true
+
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, called)
+	})
+}
+
+func TestWithContextResult(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns the function result", func(t *testing.T) {
+		t.Parallel()
+
+		value, err := withContextResult(context.Background(), func() (interface{}, error) { return "value", nil })
+		require.NoError(t, err)
+		require.Equal(t, "value", value)
+	})
+
+	t.Run("result of a function returning after the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		release, returned := make(chan struct{}), make(chan struct{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		value, err := withContextResult(ctx, func() (interface{}, error) {
+			defer close(returned)
+
+			<-release
+
+			return "late value", nil
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, value)
+
+		close(release)
+		<-returned
+
+		// the late result is dropped, not written to the returned value
+		require.Nil(t, value)
+	})
+}
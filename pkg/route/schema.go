@@ -0,0 +1,86 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// schemaFS holds the JSON schemas of the requests checked with Config.StrictSchema, one per message name.
+//
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+// msgSchemas are the compiled request schemas by message name.
+type msgSchemas map[string]*gojsonschema.Schema
+
+func loadMsgSchemas() (msgSchemas, error) {
+	schemas := msgSchemas{}
+
+	for _, name := range []string{didDocReqName, registerRouteReqName} {
+		b, err := schemaFS.ReadFile("schema/" + name + ".json")
+		if err != nil {
+			return nil, fmt.Errorf("read %s schema : %w", name, err)
+		}
+
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+		if err != nil {
+			return nil, fmt.Errorf("compile %s schema : %w", name, err)
+		}
+
+		schemas[name] = schema
+	}
+
+	return schemas, nil
+}
+
+// validate checks the message against the schema of the message name, if any. The error lists every field that
+// doesn't match, sorted.
+func (s msgSchemas) validate(name string, msg service.DIDCommMsg) error {
+	schema, ok := s[name]
+	if !ok {
+		return nil
+	}
+
+	// the v2 headers kept by fromDIDCommV2 are not fields of the v1 message the schema describes
+	msgMap := msg.Clone()
+	for _, header := range didCommV2Headers {
+		delete(msgMap, header)
+	}
+
+	b, err := json.Marshal(msgMap)
+	if err != nil {
+		return withCode(ErrCodeMsgParse, fmt.Errorf("schema validation : marshal message : %w", err))
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(b))
+	if err != nil {
+		return withCode(ErrCodeMsgParse, fmt.Errorf("schema validation : %w", err))
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	errs := make([]string, len(result.Errors()))
+
+	for i, e := range result.Errors() {
+		errs[i] = e.String()
+	}
+
+	// the validator reports the errors in no particular order
+	sort.Strings(errs)
+
+	return withCode(ErrCodeMsgParse, fmt.Errorf("schema validation : %s", strings.Join(errs, "; ")))
+}
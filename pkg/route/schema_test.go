@@ -0,0 +1,156 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+func TestStrictSchema(t *testing.T) {
+	t.Parallel()
+
+	parse := func(t *testing.T, payload string) service.DIDCommMsgMap {
+		t.Helper()
+
+		msg, err := service.ParseDIDCommMsgMap([]byte(payload))
+		require.NoError(t, err)
+
+		return msg
+	}
+
+	schemas, err := loadMsgSchemas()
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		name    string
+		payload string
+		errMsg  string
+	}{
+		"valid diddoc-req": {
+			name:    didDocReqName,
+			payload: `{"@id":"1","@type":"` + DIDDocReqMsgType + `","~transport":{"return_route":"all"}}`,
+		},
+		"diddoc-req missing id": {
+			name:    didDocReqName,
+			payload: `{"@type":"` + DIDDocReqMsgType + `"}`,
+			errMsg:  "schema validation : (root): @id is required",
+		},
+		"diddoc-req unknown field": {
+			name:    didDocReqName,
+			payload: `{"@id":"1","@type":"` + DIDDocReqMsgType + `","routerDID":"did:peer:1"}`,
+			errMsg:  "schema validation : (root): Additional property routerDID is not allowed",
+		},
+		"valid register-route-req": {
+			name: registerRouteReqName,
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},` +
+				`"data":{"didDoc":{"id":"did:peer:1"},"dryRun":true,"label":"wallet"}}`,
+		},
+		"valid extended register-route-req": {
+			name: registerRouteReqName,
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},` +
+				`"data":{"didDoc":{"id":"did:peer:1"},"endpointHint":"wss://adapter.com","protocols":["wss"],"ttl":60}}`,
+		},
+		"register-route-req missing fields": {
+			name:    registerRouteReqName,
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{},"data":{"label":5}}`,
+			errMsg: "schema validation : data.label: Invalid type. Expected: string, given: integer; " +
+				"data: didDoc is required; ~thread: pthid is required",
+		},
+		"register-route-req unknown field": {
+			name: registerRouteReqName,
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},` +
+				`"data":{"didDoc":{},"routingKeys":[]}}`,
+			errMsg: "schema validation : data: Additional property routingKeys is not allowed",
+		},
+		"no schema": {
+			name:    pingName,
+			payload: `{"@type":"` + PingMsgType + `","extra":true}`,
+		},
+	} {
+		err := schemas.validate(tc.name, parse(t, tc.payload))
+		if tc.errMsg == "" {
+			require.NoError(t, err, name)
+
+			continue
+		}
+
+		require.EqualError(t, err, tc.errMsg, name)
+		require.Equal(t, ErrCodeMsgParse, errorCode(err), name)
+	}
+
+	t.Run("strict mode", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.StrictSchema = true
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		_, err = c.callHandler(context.Background(), message.Msg{
+			DIDCommMsg: parse(t, `{"@id":"1","@type":"`+DIDDocReqMsgType+`","routerDID":"did:peer:1"}`),
+		})
+		require.EqualError(t, err, "schema validation : (root): Additional property routerDID is not allowed")
+		require.Equal(t, ErrCodeMsgParse, errorCode(err))
+	})
+
+	t.Run("strict mode DIDComm v2 request", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan service.DIDCommMsgMap, 1)
+
+		config := config()
+		config.StrictSchema = true
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		c.handleMsg(message.Msg{DIDCommMsg: parse(t, `{"id":"1","type":"`+DIDDocReqMsgType+`",`+
+			`"from":"did:peer:client","to":["did:peer:adapter"],"created_time":1622548800,`+
+			`"expires_time":4102444800,"body":{}}`)})
+
+		reply := fromDIDCommV2(<-replies)
+		require.Equal(t, DIDDocRespMsgType, reply.Type())
+
+		resp := &DIDDocResp{}
+		require.NoError(t, reply.Decode(resp))
+		require.NotNil(t, resp.Data)
+		require.NotEmpty(t, resp.Data.DIDDoc)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		_, err = c.callHandler(context.Background(), message.Msg{
+			DIDCommMsg: parse(t, `{"@id":"1","@type":"`+DIDDocReqMsgType+`","routerDID":"did:peer:1"}`),
+		})
+		require.NoError(t, err)
+	})
+}
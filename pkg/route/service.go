@@ -7,10 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package route
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
@@ -18,6 +26,7 @@ import (
 	ariescrypto "github.com/hyperledger/aries-framework-go/pkg/crypto"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/messaging/msghandler"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
 	mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/util/jwkkid"
@@ -26,36 +35,130 @@ import (
 	"github.com/hyperledger/aries-framework-go/pkg/vdr/peer"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/trustbloc/edge-core/pkg/log"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/trustbloc/edge-adapter/pkg/aries/message"
 )
 
 // Msg svc constants.
 const (
-	msgTypeBaseURI    = "https://trustbloc.dev/blinded-routing/1.0"
-	didDocReq         = msgTypeBaseURI + "/diddoc-req"
-	didDocResp        = msgTypeBaseURI + "/diddoc-resp"
-	registerRouteReq  = msgTypeBaseURI + "/register-route-req"
-	registerRouteResp = msgTypeBaseURI + "/register-route-resp"
+	msgTypeProtocolURI   = "https://trustbloc.dev/blinded-routing"
+	msgTypeVersion       = "1.0"
+	msgTypeBaseURI       = msgTypeProtocolURI + "/" + msgTypeVersion
+	didDocReqName        = "diddoc-req"
+	registerRouteReqName = "register-route-req"
+	pingName             = "ping"
+	unregisterRouteName  = "unregister-route-req"
+	// pingSvcName is the registrar name of the ping msg service, namespaced as "ping" is common to other protocols.
+	pingSvcName = "blinded-routing-ping"
 )
 
+// Blinded routing message types.
 const (
-	txnStoreName         = "msgsvc_txn"
-	didCommServiceType   = "did-communication"
-	didCommV2ServiceType = "DIDCommMessaging"
+	// DIDDocReqMsgType is the type of the router DID document request.
+	DIDDocReqMsgType = msgTypeBaseURI + "/" + didDocReqName
+	// DIDDocRespMsgType is the type of the router DID document response.
+	DIDDocRespMsgType = msgTypeBaseURI + "/diddoc-resp"
+	// RegisterRouteReqMsgType is the type of the route registration request.
+	RegisterRouteReqMsgType = msgTypeBaseURI + "/" + registerRouteReqName
+	// RegisterRouteRespMsgType is the type of the route registration response.
+	RegisterRouteRespMsgType = msgTypeBaseURI + "/register-route-resp"
+	// UnregisterRouteReqMsgType is the type of the request to tear down the route registered on the connection.
+	UnregisterRouteReqMsgType = msgTypeBaseURI + "/" + unregisterRouteName
+	// UnregisterRouteRespMsgType is the type of the route unregistration response.
+	UnregisterRouteRespMsgType = msgTypeBaseURI + "/unregister-route-resp"
+	// PingMsgType is the type of the liveness check, answered right away with a ping response.
+	PingMsgType = msgTypeBaseURI + "/" + pingName
+	// PingRespMsgType is the type of the ping response.
+	PingRespMsgType = msgTypeBaseURI + "/ping-response"
+	// ProblemReportMsgType is the type of the problem report sent on failures when problem reports are enabled.
+	ProblemReportMsgType = "https://didcomm.org/report-problem/1.0/problem-report"
 )
 
-var logger = log.New("edge-adapter/msgsvc")
+const (
+	txnStoreName          = "msgsvc_txn"
+	txnCreatedTagName     = "txnCreated"
+	routeExpiresTagName   = "routeExpires"
+	readyCheckKeyPrefix   = "ready_check_"
+	txnKeyPrefix          = "diddoc:"
+	registeredKeyPrefix   = "registered:"
+	routerDIDKeyPrefix    = "routerdid:"
+	routeExpiryKeyPrefix  = "routeexpiry:"
+	defaultTxnTTL         = 30 * time.Minute
+	minSweepWait          = time.Second
+	defaultMaxHandlers    = 8
+	defaultHandlerTimeout = time.Minute
+	defaultMaxDIDDocSize  = 64 << 10
+	defaultReplyTimeout   = 30 * time.Second
+	listenerMinBackoff    = 100 * time.Millisecond
+	listenerMaxBackoff    = 30 * time.Second
+	minProcessingTime     = time.Second
+	didCommServiceType    = "did-communication"
+	didCommV2ServiceType  = "DIDCommMessaging"
+)
+
+var logger msgLogger = log.New("edge-adapter/msgsvc")
 
 // DIDExchange client.
 type DIDExchange interface {
 	CreateConnection(myDID string, theirDID *did.Doc, options ...didexchange.ConnectionOption) (string, error)
 }
 
-// Mediator client.
+// connectionQuerier finds the connections of the DID exchange client, eg. the ones with a given DID.
+type connectionQuerier interface {
+	QueryConnections(params *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error)
+}
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Mediator client. Config returns the routing keys and endpoint of the mediator, independently of a connection.
+// The diddoc-resp includes them so the client can build its did doc for the register-route-req without another
+// round trip. NewMediator returns the Mediator of the aries mediator client.
 type Mediator interface {
 	Register(connectionID string) error
+	Unregister(connectionID string) error
 	GetConfig(connID string) (*mediatorsvc.Config, error)
+	Config() (routingKeys []string, endpoint string, err error)
+}
+
+// Metrics records the blinded routing message handling metrics.
+type Metrics interface {
+	IncMessageReceived(msgType string)
+	IncMessageError(msgType string)
+	ObserveHandlerDuration(msgType string, d time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) IncMessageReceived(string) {}
+
+func (noopMetrics) IncMessageError(string) {}
+
+func (noopMetrics) ObserveHandlerDuration(string, time.Duration) {}
+
+// safeMetrics recovers the panics of the configured Metrics, they are called outside of the handlers.
+type safeMetrics struct {
+	metrics Metrics
+}
+
+func (m safeMetrics) IncMessageReceived(msgType string) {
+	_ = callHook("metrics", func() { m.metrics.IncMessageReceived(msgType) })
+}
+
+func (m safeMetrics) IncMessageError(msgType string) {
+	_ = callHook("metrics", func() { m.metrics.IncMessageError(msgType) })
+}
+
+func (m safeMetrics) ObserveHandlerDuration(msgType string, d time.Duration) {
+	_ = callHook("metrics", func() { m.metrics.ObserveHandlerDuration(msgType, d) })
 }
 
 type connectionRecorder interface {
@@ -76,26 +179,333 @@ type Config struct {
 	KeyManager        kms.KeyManager
 	KeyType           kms.KeyType
 	KeyAgrType        kms.KeyType
+	// ServiceEndpoints are the endpoints advertised in the router DID, one didcomm service each. When set, they
+	// take precedence over ServiceEndpoint and the first one is used wherever a single endpoint is needed.
+	ServiceEndpoints []string
+	// AnyServiceEndpoint turns off the service endpoint check, for transports other than http(s) and ws(s).
+	// By default New requires absolute URLs with one of these schemes, or the aries transport queue endpoint.
+	AnyServiceEndpoint bool
+	// RouterDIDMethod is the DID method used to create the router DID returned for a diddoc-req.
+	// Defaults to peer. With web each router DID gets its own path under the first service endpoint, eg.
+	// https://adapter.com/router gives did:web:adapter.com:router:<txn id>, and WebVDR is required.
+	RouterDIDMethod string
+	// WebVDR creates the router did:web docs, eg. by publishing them on the endpoint host. The aries vdr/web
+	// only resolves did:web, it can't create them.
+	WebVDR vdr.VDR
+	// UseProblemReports replies to failed requests with an Aries RFC 0035 problem-report instead of
+	// the blinded-routing error response.
+	UseProblemReports bool
+	// Metrics records the message handling metrics. Defaults to a no-op implementation.
+	Metrics Metrics
+	// MaxConcurrentHandlers is the maximum number of messages handled concurrently. Defaults to 8.
+	MaxConcurrentHandlers int
+	// RegisterRetry is the retry policy for route registration with the mediator. Defaults to a single attempt.
+	RegisterRetry RetryPolicy
+	// SupportedVersions are the blinded routing protocol versions (major.minor) accepted in message types.
+	// Messages with the same major version as a supported one are accepted. Defaults to 1.0.
+	SupportedVersions []string
+	// TxnTTL is how long a diddoc-req transaction is kept while waiting for the matching register-route-req.
+	// Expired transactions are removed by a background sweeper. Defaults to 30 minutes.
+	TxnTTL time.Duration
+	// IDGenerator mints the IDs of outbound messages. Defaults to random UUIDs.
+	IDGenerator func() string
+	// HandlerTimeout bounds the time spent handling a single message, including the did creation, store access
+	// and route registration retries. Defaults to 1 minute.
+	HandlerTimeout time.Duration
+	// MaxDIDDocSize is the maximum size in bytes of the did doc in a register-route-req. Defaults to 64 KiB.
+	MaxDIDDocSize int
+	// TxnStoreName is the name of the store holding the transactions and the connection to router connection
+	// mappings. Services sharing a storage provider need distinct names. Defaults to msgsvc_txn.
+	TxnStoreName string
+	// TxnStoreProvider is the storage provider of the txn store, defaults to Store. A diddoc-req transaction
+	// must outlive the service process when it may restart before the register-route-req, and the connection to
+	// router connection mappings are needed for as long as the routes are in use, so in production it should be
+	// a durable provider. With an in-memory one a restart loses both: the register-route-req of a flow started
+	// before it fails with a txn-not-found error, the client then restarts the flow with a new diddoc-req.
+	TxnStoreProvider storage.Provider
+	// ReplyRetry is the retry policy for sending the replies. Defaults to a single attempt.
+	ReplyRetry RetryPolicy
+	// ErrorSanitizer maps the handler errors to the message sent to the client in the error responses and problem
+	// reports, eg. to hide internal details. The full error is logged either way. Defaults to the error text.
+	ErrorSanitizer func(error) string
+	// DebugLogPayloads logs the JSON of the inbound messages and of their replies at debug level, eg. to diagnose
+	// a failed route registration. The payloads carry did docs, set RedactPayload to mask their keys. Disabled by
+	// default.
+	DebugLogPayloads bool
+	// RedactPayload returns the payload logged with DebugLogPayloads, given a copy of the message it can modify.
+	// Defaults to the message as is.
+	RedactPayload func(payload service.DIDCommMsgMap) service.DIDCommMsgMap
+	// VerifyDIDDocProof requires the did doc of a register-route-req to carry a proof accepted by
+	// DIDDocProofVerifier, unsigned docs are rejected. Disabled by default.
+	VerifyDIDDocProof bool
+	// DIDDocProofVerifier verifies the did doc proofs, it is required with VerifyDIDDocProof.
+	DIDDocProofVerifier DIDDocProofVerifier
+	// AllowedPeerDIDMethods are the DID methods, eg. peer and key, of the did docs accepted in a
+	// register-route-req. Docs with another method are rejected before any connection is created. Empty allows
+	// all methods.
+	AllowedPeerDIDMethods []string
+	// TransformIncomingDIDDoc is called with the did doc of a register-route-req once it is validated and its proof,
+	// if any, verified, eg. to add an accept list or drop unsupported keys. The doc it returns is validated and
+	// checked against AllowedPeerDIDMethods again, then the connection is created with it. It is given its own
+	// copy of the doc. An error fails the request. Optional.
+	TransformIncomingDIDDoc func(*did.Doc) (*did.Doc, error)
+	// StrictSchema validates the diddoc-req and register-route-req against their JSON schemas before handling
+	// them. Missing or mistyped fields and unknown ones are rejected with a msg-parse-failed error listing them.
+	// Disabled by default.
+	StrictSchema bool
+	// AuditStore records every router DID created for a diddoc-req, before its transaction is saved. When the
+	// record fails the diddoc-req fails and no transaction is saved, so no DID is handed out unrecorded. A DID is
+	// recorded even if saving its transaction fails afterwards. Defaults to a no-op.
+	AuditStore AuditStore
+	// RateLimit limits the messages handled per sender, identified by their DID. Messages from senders without
+	// a DID share a global limit. Messages above the limit get a rate limited error reply. Disabled by default.
+	RateLimit RateLimit
+	// StoreRetry is the retry policy for the txn store puts and gets of the handlers. Only transient errors are
+	// retried, not found and other errors are returned right away. Defaults to a single attempt.
+	StoreRetry RetryPolicy
+	// DeadLetter is called with the message, its reply and the last error when the reply can't be sent.
+	// Optional.
+	DeadLetter func(msg service.DIDCommMsg, reply service.DIDCommMsgMap, err error)
+	// DIDDocCacheSize is the number of parsed register-route-req did docs kept to serve repeated requests.
+	// Defaults to 128, a negative value disables the cache.
+	DIDDocCacheSize int
+	// RouterServiceTemplate holds the RoutingKeys, RecipientKeys, Accept and Priority set on the did-communication
+	// services of the router did doc, one for each service endpoint. Its other fields are ignored.
+	RouterServiceTemplate did.Service
+	// ReplyTimeout bounds each attempt to send a reply. A reply that times out is retried as per ReplyRetry.
+	// Defaults to 30 seconds. An AriesMessenger that is a message.Messenger is given the timeout as a context.
+	ReplyTimeout time.Duration
+	// OnRouteRegistered is called with the router connection id and the client did doc once a register-route-req
+	// has been handled. OnDIDDocCreated is called with each router did doc created for a diddoc-req. The callbacks
+	// run in their own goroutine, concurrently with sending the reply, and Close waits for them. A panic is logged.
+	// Optional.
+	OnRouteRegistered func(connectionID string, theirDID *did.Doc)
+	OnDIDDocCreated   func(doc *did.Doc)
+	// MaxPendingTxns is the number of diddoc-req transactions kept while waiting for the register-route-req. When
+	// reached, PendingTxnsPolicy applies to new transactions. The pending ones are counted in memory, loaded from
+	// the txn store by New. Defaults to 0, ie. no limit.
+	MaxPendingTxns    int
+	PendingTxnsPolicy PendingTxnsPolicy
+	// ConnectionLabel returns the label of the connection created for a register-route-req, eg. derived from the
+	// client did, when the request has none. Optional, connections have no label by default.
+	ConnectionLabel func(theirDID *did.Doc) string
+	// ConnectionOptions are passed to DIDExchangeClient.CreateConnection for every register-route-req, eg.
+	// didexchange.WithImplicit. They come before the per-request ones, so the label of the request or from
+	// ConnectionLabel takes precedence over a didexchange.WithTheirLabel set here. Optional.
+	ConnectionOptions []didexchange.ConnectionOption
+	// ReuseExistingConnections registers the route on the existing connection to the client DID, if any, instead
+	// of creating a new connection, eg. when a route registration is retried after it created the connection.
+	// The DIDExchangeClient must then implement QueryConnections, as the aries didexchange client does.
+	ReuseExistingConnections bool
+	// Clock is the time source of the txn creation times, the txn expiry and the ping responses. Defaults to the
+	// system clock.
+	Clock Clock
+	// MediatorConfigRefresh is how long the routing keys and endpoint of the MediatorClient are cached before they
+	// are fetched again, see also RefreshMediatorConfig. Defaults to 5 minutes.
+	MediatorConfigRefresh time.Duration
+	// Tracer records a span for each diddoc-req and register-route-req, with child spans for the router did
+	// creation, the connection creation and the route registration. Defaults to a no-op tracer.
+	Tracer trace.Tracer
 }
 
+// PendingTxnsPolicy is what is done with a new diddoc-req transaction once Config.MaxPendingTxns are pending.
+type PendingTxnsPolicy int
+
+const (
+	// RejectNewTxns fails the diddoc-req with ErrCodeTooManyTxns, the client may try again later.
+	RejectNewTxns PendingTxnsPolicy = iota
+	// EvictOldestTxns removes the oldest pending transactions to make room for the new one.
+	EvictOldestTxns
+)
+
 // Service svc.
 type Service struct {
-	didExchange      DIDExchange
-	mediator         Mediator
-	messenger        service.Messenger
-	vdriRegistry     vdr.Registry
-	endpoint         string
-	store            storage.Store
-	connectionLookup connectionRecorder
-	mediatorSvc      mediatorsvc.ProtocolService
-	keyManager       kms.KeyManager
-	keyType          kms.KeyType
-	keyAgrType       kms.KeyType
+	didExchange       DIDExchange
+	mediator          Mediator
+	messenger         message.Messenger
+	vdriRegistry      vdr.Registry
+	endpoint          string
+	endpoints         []string
+	store             storage.Store
+	connectionLookup  connectionRecorder
+	mediatorSvc       mediatorsvc.ProtocolService
+	keyManager        kms.KeyManager
+	keyType           kms.KeyType
+	keyAgrType        kms.KeyType
+	routerDIDMethod   string
+	problemReports    bool
+	metrics           Metrics
+	handlers          chan struct{}
+	registerRetry     RetryPolicy
+	replyRetry        RetryPolicy
+	storeRetry        RetryPolicy
+	rateLimiter       *rateLimiter
+	proofVerifier     DIDDocProofVerifier
+	stats             *listenerStats
+	errorSanitizer    func(error) string
+	debugLogPayloads  bool
+	redactPayload     func(service.DIDCommMsgMap) service.DIDCommMsgMap
+	allowedDIDMethods []string
+	transformDIDDoc   func(*did.Doc) (*did.Doc, error)
+	schemas           msgSchemas
+	auditStore        AuditStore
+	msgServices       *msgServices
+	msgCh             chan message.Msg
+	customHandlers    *customHandlers
+	replyTimeout      time.Duration
+	deadLetter        func(service.DIDCommMsg, service.DIDCommMsgMap, error)
+	versions          []protocolVersion
+	txnTTL            time.Duration
+	newID             func() string
+	didDocReqs        singleflight.Group
+	routeExpiryMu     sync.Mutex
+	nextRouteExpiry   time.Time
+	routeExpiryCh     chan struct{}
+	registering       sync.Map
+	handlerTimeout    time.Duration
+	maxDIDDocSize     int
+	didDocs           *didDocCache
+	routerService     did.Service
+	webDID            *webDID
+	onRouteRegistered func(connectionID string, theirDID *did.Doc)
+	onDIDDocCreated   func(doc *did.Doc)
+	tracer            trace.Tracer
+	connectionLabel   func(theirDID *did.Doc) string
+	connections       connectionQuerier
+	connOpts          []didexchange.ConnectionOption
+	mediatorConfig    *mediatorConfigCache
+	clock             Clock
+	maxPendingTxns    int
+	pendingTxns       *pendingTxns
+	pendingTxnsPolicy PendingTxnsPolicy
+	ctx               context.Context
+	cancel            context.CancelFunc
+	done              chan struct{}
+	closeOnce         sync.Once
+	closeMu           sync.RWMutex
+	routines          sync.WaitGroup
+}
+
+// validate checks that the dependencies the handlers can't do without are set. The error names all missing ones.
+func (c *Config) validate() error {
+	var missing []string
+
+	for _, field := range []struct {
+		name  string
+		isNil bool
+	}{
+		{name: "DIDExchangeClient", isNil: c.DIDExchangeClient == nil},
+		{name: "MediatorClient", isNil: c.MediatorClient == nil},
+		{name: "AriesMessenger", isNil: c.AriesMessenger == nil},
+		{name: "MsgRegistrar", isNil: c.MsgRegistrar == nil},
+		{name: "VDRIRegistry", isNil: c.VDRIRegistry == nil},
+		{name: "Store", isNil: c.Store == nil && c.TxnStoreProvider == nil},
+	} {
+		if field.isNil {
+			missing = append(missing, field.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields : %s", strings.Join(missing, ", "))
+	}
+
+	return nil
 }
 
 // New returns a new Service.
 func New(config *Config) (*Service, error) {
-	store, err := getTxnStore(config.Store)
+	err := config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("config : %w", err)
+	}
+
+	routerDIDMethod := config.RouterDIDMethod
+	if routerDIDMethod == "" {
+		routerDIDMethod = peer.DIDMethod
+	}
+
+	if strings.TrimSpace(routerDIDMethod) == "" {
+		return nil, errors.New("router did method must not be blank")
+	}
+
+	versions, err := parseSupportedVersions(config.SupportedVersions)
+	if err != nil {
+		return nil, fmt.Errorf("supported versions: %w", err)
+	}
+
+	endpoints := config.ServiceEndpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{config.ServiceEndpoint}
+	}
+
+	if !config.AnyServiceEndpoint {
+		for _, endpoint := range endpoints {
+			err = validateServiceEndpoint(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("service endpoint : %w", err)
+			}
+		}
+	}
+
+	var web *webDID
+
+	if routerDIDMethod == WebDIDMethod {
+		if config.WebVDR == nil {
+			return nil, errors.New("router did method web requires a WebVDR")
+		}
+
+		web, err = newWebDID(endpoints[0], config.WebVDR)
+		if err != nil {
+			return nil, fmt.Errorf("router did:web from endpoint : %w", err)
+		}
+	}
+
+	txnStore := config.TxnStoreName
+	if txnStore == "" {
+		txnStore = txnStoreName
+	}
+
+	if strings.TrimSpace(txnStore) == "" {
+		return nil, errors.New("txn store name must not be blank")
+	}
+
+	var proofVerifier DIDDocProofVerifier
+
+	if config.VerifyDIDDocProof {
+		if config.DIDDocProofVerifier == nil {
+			return nil, errors.New("verify did doc proof : did doc proof verifier required")
+		}
+
+		proofVerifier = config.DIDDocProofVerifier
+	}
+
+	var schemas msgSchemas
+
+	if config.StrictSchema {
+		schemas, err = loadMsgSchemas()
+		if err != nil {
+			return nil, fmt.Errorf("strict schema : %w", err)
+		}
+	}
+
+	var connections connectionQuerier
+
+	if config.ReuseExistingConnections {
+		var ok bool
+
+		connections, ok = config.DIDExchangeClient.(connectionQuerier)
+		if !ok {
+			return nil, errors.New("reuse existing connections : did exchange client can't query connections")
+		}
+	}
+
+	txnStoreProvider := config.TxnStoreProvider
+	if txnStoreProvider == nil {
+		txnStoreProvider = config.Store
+	}
+
+	store, err := getTxnStore(txnStoreProvider, txnStore)
 	if err != nil {
 		return nil, fmt.Errorf("store: %w", err)
 	}
@@ -103,33 +513,246 @@ func New(config *Config) (*Service, error) {
 	o := &Service{
 		didExchange:      config.DIDExchangeClient,
 		mediator:         config.MediatorClient,
-		messenger:        config.AriesMessenger,
+		messenger:        message.NewMessenger(config.AriesMessenger),
 		vdriRegistry:     config.VDRIRegistry,
-		endpoint:         config.ServiceEndpoint,
+		endpoint:         endpoints[0],
+		endpoints:        endpoints,
 		store:            store,
 		connectionLookup: config.ConnectionLookup,
 		// TODO https://github.com/trustbloc/edge-adapter/issues/361 use function from client
-		mediatorSvc: config.MediatorSvc,
-		keyManager:  config.KeyManager,
-		keyType:     config.KeyType,
-		keyAgrType:  config.KeyAgrType,
+		mediatorSvc:       config.MediatorSvc,
+		keyManager:        config.KeyManager,
+		keyType:           config.KeyType,
+		keyAgrType:        config.KeyAgrType,
+		routerDIDMethod:   routerDIDMethod,
+		problemReports:    config.UseProblemReports,
+		metrics:           config.Metrics,
+		registerRetry:     config.RegisterRetry,
+		replyRetry:        config.ReplyRetry,
+		storeRetry:        config.StoreRetry,
+		allowedDIDMethods: config.AllowedPeerDIDMethods,
+		transformDIDDoc:   config.TransformIncomingDIDDoc,
+		schemas:           schemas,
+		auditStore:        config.AuditStore,
+		replyTimeout:      config.ReplyTimeout,
+		deadLetter:        config.DeadLetter,
+		versions:          versions,
+		txnTTL:            config.TxnTTL,
+		newID:             config.IDGenerator,
+		handlerTimeout:    config.HandlerTimeout,
+		maxDIDDocSize:     config.MaxDIDDocSize,
+		routerService:     config.RouterServiceTemplate,
+		webDID:            web,
+		onRouteRegistered: config.OnRouteRegistered,
+		onDIDDocCreated:   config.OnDIDDocCreated,
+		tracer:            config.Tracer,
+		connectionLabel:   config.ConnectionLabel,
+		connections:       connections,
+		connOpts:          config.ConnectionOptions,
+		proofVerifier:     proofVerifier,
+		stats:             newListenerStats(),
+		errorSanitizer:    config.ErrorSanitizer,
+		debugLogPayloads:  config.DebugLogPayloads,
+		redactPayload:     config.RedactPayload,
+		clock:             config.Clock,
+		maxPendingTxns:    config.MaxPendingTxns,
+		pendingTxns:       newPendingTxns(),
+		pendingTxnsPolicy: config.PendingTxnsPolicy,
+		routeExpiryCh:     make(chan struct{}, 1),
+		done:              make(chan struct{}),
+	}
+
+	if o.txnTTL <= 0 {
+		o.txnTTL = defaultTxnTTL
+	}
+
+	if o.handlerTimeout <= 0 {
+		o.handlerTimeout = defaultHandlerTimeout
+	}
+
+	cacheSize := config.DIDDocCacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultDIDDocCacheSize
+	}
+
+	o.didDocs = newDIDDocCache(cacheSize)
+
+	if o.replyTimeout <= 0 {
+		o.replyTimeout = defaultReplyTimeout
+	}
+
+	if o.maxDIDDocSize <= 0 {
+		o.maxDIDDocSize = defaultMaxDIDDocSize
+	}
+
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+
+	if o.newID == nil {
+		o.newID = uuid.New().String
+	}
+
+	if o.metrics == nil {
+		o.metrics = noopMetrics{}
+	} else {
+		o.metrics = safeMetrics{metrics: o.metrics}
+	}
+
+	if o.auditStore == nil {
+		o.auditStore = noopAuditStore{}
+	}
+
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+
+	o.mediatorConfig = newMediatorConfigCache(o.mediator, config.MediatorConfigRefresh, o.clock)
+
+	if o.tracer == nil {
+		o.tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+
+	o.rateLimiter = newRateLimiter(config.RateLimit, o.clock)
+
+	maxHandlers := config.MaxConcurrentHandlers
+	if maxHandlers <= 0 {
+		maxHandlers = defaultMaxHandlers
+	}
+
+	if o.maxPendingTxns > 0 {
+		// the txns left by a previous run with a durable txn store
+		txns, errQuery := o.storedTxns()
+		if errQuery != nil {
+			return nil, fmt.Errorf("load pending txns : %w", errQuery)
+		}
+
+		o.pendingTxns.load(txns)
 	}
 
+	o.handlers = make(chan struct{}, maxHandlers)
+
 	msgCh := make(chan message.Msg, 1)
 
-	err = config.MsgRegistrar.Register(
-		message.NewMsgSvc("diddoc-req", didDocReq, msgCh),
-		message.NewMsgSvc("register-route-req", registerRouteReq, msgCh),
+	o.msgServices = &msgServices{registrar: config.MsgRegistrar}
+	o.msgCh = msgCh
+	o.customHandlers = newCustomHandlers()
+
+	// the registrar checks all the names before adding any service, on error none is registered and New may be
+	// called again with the same registrar
+	err = o.msgServices.register(
+		message.NewMsgSvcWithMatcher(didDocReqName, o.acceptMsg(didDocReqName), msgCh),
+		message.NewMsgSvcWithMatcher(registerRouteReqName, o.acceptMsg(registerRouteReqName), msgCh),
+		message.NewMsgSvcWithMatcher(pingSvcName, o.acceptMsg(pingName), msgCh),
+		message.NewMsgSvcWithMatcher(unregisterRouteName, o.acceptMsg(unregisterRouteName), msgCh),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("message service client: %w", err)
+		return nil, fmt.Errorf("register msg services : %w", err)
 	}
 
-	go o.didCommMsgListener(msgCh)
+	o.routines.Add(2) // nolint:gomnd // listener and txn sweeper
+
+	go func() {
+		defer o.routines.Done()
+
+		o.superviseListener(func() { o.didCommMsgListener(msgCh) })
+	}()
+
+	go func() {
+		defer o.routines.Done()
+
+		o.txnSweeper()
+	}()
 
 	return o, nil
 }
 
+// RegisteredTypes returns the message types handled by the service, followed by the ones registered with
+// RegisterHandler.
+func (o *Service) RegisteredTypes() []string {
+	return append([]string{DIDDocReqMsgType, RegisterRouteReqMsgType, PingMsgType, UnregisterRouteReqMsgType},
+		o.customHandlers.registeredTypes()...)
+}
+
+// Ready checks that the service is running and its txn store is usable by writing, reading back and deleting a
+// sentinel entry. It returns the first failure, or the context error if the context is done first.
+func (o *Service) Ready(ctx context.Context) error {
+	select {
+	case <-o.done:
+		return errors.New("service is closed")
+	default:
+	}
+
+	// unique per call, concurrent probes don't see each other's entry
+	key := readyCheckKeyPrefix + o.newID()
+
+	// if the context is done first, the probe carries on in the background and still deletes its entry
+	return withContext(ctx, func() error {
+		return o.readyProbe(key)
+	})
+}
+
+// readyProbe writes, reads back and deletes the entry at the key. The entry is deleted whatever the check result.
+func (o *Service) readyProbe(key string) (err error) {
+	value := []byte(key)
+
+	err = o.store.Put(key, value)
+	if err != nil {
+		return fmt.Errorf("txn store put : %w", err)
+	}
+
+	defer func() {
+		errDelete := o.store.Delete(key)
+		if errDelete != nil && err == nil {
+			err = fmt.Errorf("txn store delete : %w", errDelete)
+		}
+	}()
+
+	stored, err := o.store.Get(key)
+	if err != nil {
+		return fmt.Errorf("txn store get : %w", err)
+	}
+
+	if !bytes.Equal(stored, value) {
+		return errors.New("txn store get : unexpected value")
+	}
+
+	return nil
+}
+
+// Close unregisters the message services from the MsgRegistrar, so no new message reaches the service, then
+// stops the message listener and the txn sweeper. Messages already queued are processed before the listener
+// exits, the ones still handed over by the message services are dropped. Close returns once both, and any
+// message handlers still running, have stopped or the context is done, whichever happens first. In the latter
+// case the handlers still running are cancelled. An unregister error is returned once the listener has stopped.
+func (o *Service) Close(ctx context.Context) error {
+	var unregisterErr error
+
+	o.closeOnce.Do(func() {
+		unregisterErr = o.msgServices.unregisterAll()
+
+		// no exported handler call starts once done is closed, Wait below doesn't race with their Add
+		o.closeMu.Lock()
+		close(o.done)
+		o.closeMu.Unlock()
+	})
+
+	stopped := make(chan struct{})
+
+	go func() {
+		o.routines.Wait()
+		close(stopped)
+	}()
+
+	// releases the service context, cancelling the handlers still running on timeout
+	defer o.cancel()
+
+	select {
+	case <-stopped:
+		return unregisterErr
+	case <-ctx.Done():
+		return fmt.Errorf("wait for listener to stop : %w", ctx.Err())
+	}
+}
+
 // GetDIDDoc returns the did doc with router endpoint/keys if its registered, else returns the doc
 // with default endpoint.
 //nolint:gocyclo,funlen,cyclop
@@ -238,203 +861,1856 @@ func (o *Service) GetDIDDoc(connID string, requiresBlindedRoute, isDIDcommV1 boo
 	return newDidDoc, nil
 }
 
-func (o *Service) didCommMsgListener(ch <-chan message.Msg) {
-	for msg := range ch {
-		var err error
+// superviseListener runs the listener until the service is closed, restarting it with an exponential backoff if it
+// returns or panics before. The backoff is reset once the listener has run for listenerMaxBackoff.
+func (o *Service) superviseListener(listen func()) {
+	backoff := listenerMinBackoff
 
-		var msgMap service.DIDCommMsgMap
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
 
-		switch msg.DIDCommMsg.Type() {
-		case didDocReq:
-			msgMap, err = o.handleDIDDocReq(msg.DIDCommMsg)
-		case registerRouteReq:
-			msgMap, err = o.handleRouteRegistration(msg)
+		err := runListener(listen)
+
+		select {
+		case <-o.done:
+			return
 		default:
-			err = fmt.Errorf("unsupported message service type : %s", msg.DIDCommMsg.Type())
 		}
 
-		if err != nil {
-			msgType := msg.DIDCommMsg.Type()
+		if time.Since(started) >= listenerMaxBackoff {
+			backoff = listenerMinBackoff
+		}
 
-			switch msg.DIDCommMsg.Type() {
-			case didDocReq:
-				msgType = didDocResp
-			case registerRouteReq:
-				msgType = registerRouteResp
-			}
+		fields := logFields{}.with("restart", strconv.Itoa(restarts+1))
+		if err != nil {
+			fields = fields.withErr(err)
+		}
 
-			msgMap = service.NewDIDCommMsgMap(&ErrorResp{
-				ID:   uuid.New().String(),
-				Type: msgType,
-				Data: &ErrorRespData{ErrorMsg: err.Error()},
-			})
+		fields.errorf("message listener exited unexpectedly, restarting in %s", backoff)
 
-			logger.Errorf("msgType=[%s] id=[%s] errMsg=[%s]", msg.DIDCommMsg.Type(), msg.DIDCommMsg.ID(), err.Error())
+		// on close the listener is restarted right away to drain the queued messages
+		select {
+		case <-time.After(backoff):
+		case <-o.done:
 		}
 
-		err = o.messenger.ReplyTo(msg.DIDCommMsg.ID(), msgMap) // nolint:staticcheck //issue#403
-		if err != nil {
-			logger.Errorf("sendReply : msgType=[%s] id=[%s] errMsg=[%s]",
-				msg.DIDCommMsg.Type(), msg.DIDCommMsg.ID(), err.Error())
+		backoff *= 2
+		if backoff > listenerMaxBackoff {
+			backoff = listenerMaxBackoff
+		}
+	}
+}
 
-			continue
+// runListener calls listen and returns the panic it recovers, if any.
+func runListener(listen func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("listener panic : %v", r)
 		}
+	}()
 
-		logger.Infof("msgType=[%s] id=[%s] msg=[%s]", msg.DIDCommMsg.Type(), msg.DIDCommMsg.ID(), "success")
-	}
+	listen()
+
+	return nil
 }
 
-func (o *Service) handleDIDDocReq(msg service.DIDCommMsg) (service.DIDCommMsgMap, error) {
-	verMethod, err := o.newVerificationMethod(kms.ED25519Type)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new verification method: %w", err)
+func (o *Service) didCommMsgListener(ch <-chan message.Msg) {
+	for {
+		select {
+		case msg := <-ch:
+			o.dispatch(msg)
+		case <-o.done:
+			// drain the messages that are already queued
+			for {
+				select {
+				case msg := <-ch:
+					o.dispatch(msg)
+				default:
+					return
+				}
+			}
+		}
 	}
+}
 
-	kaVM, err := o.newVerificationMethod(o.keyAgrType)
+// RefreshMediatorConfig fetches the routing keys and endpoint of the mediator, replacing the cached ones, eg.
+// once the mediator config changed. On error the cached config is kept.
+func (o *Service) RefreshMediatorConfig(ctx context.Context) error {
+	err := o.mediatorConfig.forceRefresh(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new keyagreement VM: %w", err)
+		return fmt.Errorf("refresh mediator config : %w", err)
 	}
 
-	ka := did.NewReferencedVerification(kaVM, did.KeyAgreement)
+	return nil
+}
 
-	docResolution, err := o.vdriRegistry.Create(
-		peer.DIDMethod,
-		&did.Doc{
-			Service: []did.Service{{
-				Type:            didCommServiceType,
-				ServiceEndpoint: model.NewDIDCommV1Endpoint(o.endpoint),
-			}},
-			VerificationMethod: []did.VerificationMethod{*verMethod},
-			KeyAgreement:       []did.Verification{*ka},
-		})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create peer did: %w", err)
-	}
+// Stats returns a snapshot of the messages in flight and handled so far.
+func (o *Service) Stats() Stats {
+	return o.stats.snapshot(o.clock.Now())
+}
 
-	newDidDoc := docResolution.DIDDocument
+// dispatch handles the message in a new goroutine, blocking while MaxConcurrentHandlers messages are in flight.
+func (o *Service) dispatch(msg message.Msg) {
+	id := o.stats.start(o.clock.Now())
 
-	err = o.store.Put(msg.ID(), []byte(newDidDoc.ID))
-	if err != nil {
-		return nil, fmt.Errorf("save txn data : %w", err)
-	}
+	o.handlers <- struct{}{}
 
-	docBytes, err := newDidDoc.JSONBytes()
-	if err != nil {
-		return nil, fmt.Errorf("marshal did doc : %w", err)
-	}
+	o.routines.Add(1)
 
-	// send the did doc
-	return service.NewDIDCommMsgMap(&DIDDocResp{
-		ID:   uuid.New().String(),
-		Type: didDocResp,
-		Data: &DIDDocRespData{
-			DIDDoc: docBytes,
-		},
-	}), nil
+	go func() {
+		defer func() {
+			o.stats.done(id)
+			<-o.handlers
+			o.routines.Done()
+		}()
+
+		o.handleMsg(msg)
+	}()
 }
 
-const (
-	ed25519VerificationKey2018 = "Ed25519VerificationKey2018"
-	x25519KeyAgreementKey2019  = "X25519KeyAgreementKey2019"
-	jsonWebKey2020             = "JsonWebKey2020"
-)
+func (o *Service) handleMsg(msg message.Msg) {
+	var err error
 
-// TODO: copied from mediator, should push shared code upstream
-func (o *Service) newVerificationMethod(kt kms.KeyType) (*did.VerificationMethod, error) {
-	kid, pkBytes, err := o.keyManager.CreateAndExportPubKeyBytes(kt)
-	if err != nil {
-		return nil, fmt.Errorf("creating public key: %w", err)
+	var msgMap service.DIDCommMsgMap
+
+	var replyOpts []service.Opt
+
+	o.logPayload(msgLogFields(msg), msg.DIDCommMsg, "inbound payload")
+
+	// the handlers understand the v1 envelope, a v2 message is converted and so is its reply
+	v2Msg, v2 := didCommV2Msg(msg.DIDCommMsg)
+	if v2 {
+		msg.DIDCommMsg = fromDIDCommV2(v2Msg)
+		replyOpts = append(replyOpts, service.WithVersion(service.V2))
 	}
 
-	id := "#" + kid
+	o.metrics.IncMessageReceived(msg.DIDCommMsg.Type())
 
-	var vm *did.VerificationMethod
+	start := time.Now()
 
-	switch kt { // nolint:exhaustive // most cases can use the default.
-	case kms.ED25519Type:
-		vm = did.NewVerificationMethodFromBytes(id, ed25519VerificationKey2018, "", pkBytes)
-	case kms.X25519ECDHKWType:
-		key := &ariescrypto.PublicKey{}
+	ctx, cancel := context.WithTimeout(o.ctx, o.handlerTimeout)
+	defer cancel()
 
-		err = json.Unmarshal(pkBytes, key)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal X25519 key: %w", err)
-		}
+	msgMap, err = o.callHandler(ctx, msg)
 
-		vm = did.NewVerificationMethodFromBytes(id, x25519KeyAgreementKey2019, "", key.X)
-	default:
-		j, err := jwkkid.BuildJWK(pkBytes, kt)
-		if err != nil {
-			return nil, fmt.Errorf("creating jwk: %w", err)
+	o.metrics.ObserveHandlerDuration(msg.DIDCommMsg.Type(), time.Since(start))
+
+	fields := msgLogFields(msg)
+
+	handlerFailed := err != nil
+
+	if handlerFailed {
+		o.metrics.IncMessageError(msg.DIDCommMsg.Type())
+		o.stats.failed()
+
+		msgMap = o.errorResp(msg.DIDCommMsg, err)
+
+		fields.withErr(err).errorf("handle message")
+	}
+
+	if v2 {
+		msgMap = toDIDCommV2(msgMap)
+	}
+
+	o.logPayload(fields, msgMap, "reply payload")
+
+	// not bounded by the handler context, a handler that timed out still gets its error reply sent
+	err = retry(o.ctx, o.replyRetry, o.done, func(error) bool { return true }, func() error {
+		replyCtx, cancelReply := context.WithTimeout(o.ctx, o.replyTimeout)
+		defer cancelReply()
+
+		return o.messenger.ReplyToWithContext(replyCtx, msg.DIDCommMsg.ID(), msgMap, replyOpts...)
+	})
+	if err != nil {
+		if !handlerFailed {
+			o.stats.failed()
 		}
 
-		j.KeyID = kid
+		fields.withErr(err).errorf("send reply")
 
-		vm, err = did.NewVerificationMethodFromJWK(id, jsonWebKey2020, "", j)
-		if err != nil {
-			return nil, fmt.Errorf("creating verification method: %w", err)
+		if o.deadLetter != nil {
+			_ = callHook("dead letter", func() { o.deadLetter(msg.DIDCommMsg, msgMap, err) })
 		}
+
+		return
 	}
 
-	return vm, nil
+	fields.infof("message handled")
 }
 
-func (o *Service) handleRouteRegistration(msg message.Msg) (service.DIDCommMsgMap, error) { // nolint: gocyclo,cyclop
-	pMsg := ConnReq{}
+// callHandler calls the handler of the message. A handler panic is logged and returned as an internal error, so
+// the client gets an error reply and the other messages are still handled.
+func (o *Service) callHandler(ctx context.Context, msg message.Msg) (msgMap service.DIDCommMsgMap, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msgLogFields(msg).with(logFieldStack, string(debug.Stack())).errorf("handler panic : %v", r)
 
-	err := msg.DIDCommMsg.Decode(&pMsg)
-	if err != nil {
-		return nil, fmt.Errorf("parse didcomm message : %w", err)
-	}
+			msgMap, err = nil, withCode(ErrCodeInternal, fmt.Errorf("handler panic : %v", r))
+		}
+	}()
 
-	if msg.DIDCommMsg.ParentThreadID() == "" {
-		return nil, errors.New("parent thread id mandatory")
+	if !o.rateLimiter.allow(msg.TheirDID) {
+		return nil, withCode(ErrCodeRateLimited, errors.New("rate limited, too many messages, try again later"))
 	}
 
-	if pMsg.Data == nil || pMsg.Data.DIDDoc == nil {
-		return nil, errors.New("did document mandatory")
-	}
+	name := o.msgName(msg.DIDCommMsg.Type())
 
-	didDoc, err := did.ParseDocument(pMsg.Data.DIDDoc)
+	err = o.schemas.validate(name, msg.DIDCommMsg)
 	if err != nil {
-		return nil, fmt.Errorf("parse did doc : %w", err)
+		return nil, err
 	}
 
-	txnID, err := o.store.Get(msg.DIDCommMsg.ParentThreadID())
+	expires, err := msgExpiry(msg.DIDCommMsg)
 	if err != nil {
-		return nil, fmt.Errorf("fetch txn data : %w", err)
+		return nil, withCode(ErrCodeMsgParse, fmt.Errorf("parse timing decorator : %w", err))
 	}
 
-	routerConnID, err := o.didExchange.CreateConnection(string(txnID), didDoc)
-	if err != nil {
-		return nil, fmt.Errorf("create connection : %w", err)
-	}
+	if !expires.IsZero() {
+		// the sender gives up on the message at its expiry, don't start work that can't complete before
+		remaining := expires.Sub(o.clock.Now())
+		if remaining < minProcessingTime {
+			return nil, withCode(ErrCodeMsgExpired, fmt.Errorf("message expired at %s",
+				expires.UTC().Format(time.RFC3339)))
+		}
 
-	err = o.mediator.Register(routerConnID)
-	if err != nil {
-		return nil, fmt.Errorf("route registration : %w", err)
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, remaining)
+		defer cancel()
 	}
 
-	connID, err := o.connectionLookup.GetConnectionIDByDIDs(msg.MyDID, msg.TheirDID)
-	if err != nil {
-		return nil, fmt.Errorf("get connection by dids : %w", err)
+	switch name {
+	case didDocReqName:
+		return o.handleDIDDocReq(ctx, msg)
+	case registerRouteReqName:
+		return o.handleRouteRegistration(ctx, msg)
+	case pingName:
+		return o.pingResp(msg.DIDCommMsg), nil
+	case unregisterRouteName:
+		return o.handleUnregisterRoute(ctx, msg)
 	}
 
-	err = o.store.Put(connID, []byte(routerConnID))
-	if err != nil {
-		return nil, fmt.Errorf("save connID to routerConnID mapping : %w", err)
+	if handler, ok := o.customHandlers.get(msg.DIDCommMsg.Type()); ok {
+		return handler(ctx, msg.DIDCommMsg)
 	}
 
-	return service.NewDIDCommMsgMap(&ConnResp{
-		ID:   uuid.New().String(),
-		Type: registerRouteResp,
-	}), nil
+	return nil, withCode(ErrCodeUnsupportedMsgType, fmt.Errorf(
+		"unsupported message service type : %s (supported versions: %s)",
+		msg.DIDCommMsg.Type(), o.supportedVersions()))
 }
 
-func getTxnStore(prov storage.Provider) (storage.Store, error) {
-	txnStore, err := prov.OpenStore(txnStoreName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open txn store: %w", err)
+func (o *Service) errorResp(msg service.DIDCommMsg, err error) service.DIDCommMsgMap {
+	if o.problemReports {
+		return service.NewDIDCommMsgMap(&ProblemReport{
+			ID:     o.newID(),
+			Type:   ProblemReportMsgType,
+			Thread: &decorator.Thread{ID: msg.ID()},
+			Description: &ProblemReportDescription{
+				Code:    errorCode(err),
+				Message: o.errorMsg(err),
+			},
+		})
+	}
+
+	msgType := msg.Type()
+
+	switch o.msgName(msg.Type()) {
+	case didDocReqName:
+		msgType = DIDDocRespMsgType
+	case registerRouteReqName:
+		msgType = RegisterRouteRespMsgType
+	case pingName:
+		msgType = PingRespMsgType
+	case unregisterRouteName:
+		msgType = UnregisterRouteRespMsgType
+	}
+
+	return service.NewDIDCommMsgMap(&ErrorResp{
+		ID:   o.newID(),
+		Type: msgType,
+		Data: &ErrorRespData{Code: errorCode(err), ErrorMsg: o.errorMsg(err)},
+	})
+}
+
+// errorMsg returns the client-facing message of the error. If the sanitizer panics it is the error code, the
+// error itself may hold the details the sanitizer hides.
+func (o *Service) errorMsg(err error) string {
+	if o.errorSanitizer != nil {
+		var msg string
+
+		if callHook("error sanitizer", func() { msg = o.errorSanitizer(err) }) != nil {
+			return errorCode(err)
+		}
+
+		return msg
+	}
+
+	return err.Error()
+}
+
+// pingResp returns the response to the ping, in its thread, with the time it was handled.
+func (o *Service) pingResp(msg service.DIDCommMsg) service.DIDCommMsgMap {
+	return service.NewDIDCommMsgMap(&PingResp{
+		ID:        o.newID(),
+		Type:      PingRespMsgType,
+		Thread:    &decorator.Thread{ID: msg.ID()},
+		Timestamp: o.clock.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// HandleDIDDocReq handles the router DID document request and returns the response without sending it. It fails
+// once the service is closed.
+func (o *Service) HandleDIDDocReq(ctx context.Context, msg service.DIDCommMsg) (service.DIDCommMsgMap, error) {
+	err := o.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer o.routines.Done()
+
+	return o.handleDIDDocReq(ctx, message.Msg{DIDCommMsg: msg})
+}
+
+// HandleConnReq handles the route registration request and returns the response without sending it. It fails
+// once the service is closed.
+func (o *Service) HandleConnReq(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	err := o.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer o.routines.Done()
+
+	return o.handleRouteRegistration(ctx, msg)
+}
+
+// HandleUnregisterRouteReq handles the route unregistration request and returns the response without sending it.
+// It fails once the service is closed.
+func (o *Service) HandleUnregisterRouteReq(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	err := o.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer o.routines.Done()
+
+	return o.handleUnregisterRoute(ctx, msg)
+}
+
+// enter counts an exported handler call in the routines Close waits for, the caller must call routines.Done when
+// it returns. It fails once the service is closed.
+func (o *Service) enter() error {
+	o.closeMu.RLock()
+	defer o.closeMu.RUnlock()
+
+	select {
+	case <-o.done:
+		return errors.New("service is closed")
+	default:
+	}
+
+	o.routines.Add(1)
+
+	return nil
+}
+
+func (o *Service) handleDIDDocReq(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	ctx, span := o.tracer.Start(ctx, spanDIDDocReq, msgSpanAttrs(msg.DIDCommMsg))
+
+	resp, err := o.didDocResp(ctx, msg)
+
+	endSpan(span, err)
+
+	return resp, err
+}
+
+func (o *Service) didDocResp(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	// fetched first, a failure must not leave a router did nor a txn behind
+	routingKeys, routingEndpoint, err := o.mediatorConfig.get(ctx)
+	if err != nil {
+		return nil, withCode(ErrCodeMediatorConfig, fmt.Errorf("get mediator config : %w", err))
+	}
+
+	// concurrent requests with the same id share the did, later ones find it in the txn store
+	txn, err, _ := o.didDocReqs.Do(msg.DIDCommMsg.ID(), func() (interface{}, error) {
+		return o.txnDIDDoc(ctx, msg.DIDCommMsg.ID(), msg.TheirDID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// send the did doc
+	return service.NewDIDCommMsgMap(&DIDDocResp{
+		ID:   o.newID(),
+		Type: DIDDocRespMsgType,
+		Data: &DIDDocRespData{
+			DID:             txn.(*txnData).DID,
+			DIDDoc:          txn.(*txnData).DIDDoc,
+			RoutingKeys:     routingKeys,
+			RoutingEndpoint: routingEndpoint,
+		},
+	}), nil
+}
+
+// txnDIDDoc returns the router did and did doc created for the diddoc-req transaction, creating them if needed.
+func (o *Service) txnDIDDoc(ctx context.Context, txnID, theirDID string) (*txnData, error) {
+	_, txnBytes, err := o.getTxn(ctx, txnID)
+
+	switch {
+	case err == nil:
+		txn := parseTxnData(txnBytes)
+		if txn.DIDDoc != nil {
+			return txn, nil
+		}
+		// the txn predates storing the did doc, replace it with a new one
+	case !errors.Is(err, storage.ErrDataNotFound):
+		return nil, withCode(ErrCodeTxnFetch, fmt.Errorf("fetch txn data : %w", err))
+	}
+
+	err = withContext(ctx, o.checkPendingTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	newDidDoc, err := o.createRouterDID(ctx, txnID)
+	if err != nil {
+		return nil, err
+	}
+
+	docBytes, err := newDidDoc.JSONBytes()
+	if err != nil {
+		return nil, fmt.Errorf("marshal did doc : %w", err)
+	}
+
+	// stored canonical so the txn content does not depend on the marshaler's key order
+	docBytes, err = message.CanonicalJSON(docBytes)
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize did doc : %w", err)
+	}
+
+	txn := &txnData{DID: newDidDoc.ID, DIDDoc: docBytes}
+
+	txnBytes, err = json.Marshal(txn)
+	if err != nil {
+		return nil, fmt.Errorf("marshal txn data : %w", err)
+	}
+
+	// recorded before the txn is saved, a did found in the txn store, eg. by a retried diddoc-req, is audited
+	err = o.auditStore.RecordDIDCreated(ctx, &DIDCreatedRecord{
+		MsgID:     txnID,
+		DID:       newDidDoc.ID,
+		TheirDID:  theirDID,
+		Endpoint:  o.endpoint,
+		CreatedAt: o.clock.Now().UTC(),
+	})
+	if err != nil {
+		return nil, withCode(ErrCodeAuditRecord, fmt.Errorf("record did created : %w", err))
+	}
+
+	// untagged, the marker is kept after the txn expires: a router did is never accepted as a client did
+	err = o.withStore(ctx, func() error {
+		return o.store.Put(routerDIDKey(newDidDoc.ID), []byte(txnID))
+	})
+	if err != nil {
+		return nil, withCode(ErrCodeTxnSave, fmt.Errorf("save router did : %w", err))
+	}
+
+	err = o.withStore(ctx, func() error {
+		return o.store.Put(txnKey(txnID), txnBytes, o.txnCreatedTag())
+	})
+	if err != nil {
+		return nil, withCode(ErrCodeTxnSave, fmt.Errorf("save txn data : %w", err))
+	}
+
+	o.pendingTxns.add(txnKey(txnID))
+
+	if o.onDIDDocCreated != nil {
+		o.notify(func() { o.onDIDDocCreated(newDidDoc) })
+	}
+
+	return txn, nil
+}
+
+// CreateRouterDID creates a router did, as sent in the diddoc-resp, without a diddoc-req transaction. It lets
+// operators provision a router did for a client outside of the DIDComm flow.
+func (o *Service) CreateRouterDID() (*did.Doc, error) {
+	ctx, cancel := context.WithTimeout(o.ctx, o.handlerTimeout)
+	defer cancel()
+
+	return o.createRouterDID(ctx, o.newID())
+}
+
+// createRouterDID creates a router did with the configured method, service endpoints and service template. The
+// txn id gives the did:web router did its own path.
+func (o *Service) createRouterDID(ctx context.Context, txnID string) (*did.Doc, error) {
+	verMethod, err := o.newVerificationMethod(kms.ED25519Type)
+	if err != nil {
+		return nil, withCode(ErrCodeDIDCreation, fmt.Errorf("failed to create new verification method: %w", err))
+	}
+
+	kaVM, err := o.newVerificationMethod(o.keyAgrType)
+	if err != nil {
+		return nil, withCode(ErrCodeDIDCreation, fmt.Errorf("failed to create new keyagreement VM: %w", err))
+	}
+
+	ka := did.NewReferencedVerification(kaVM, did.KeyAgreement)
+
+	doc := &did.Doc{
+		Service:            o.routerServices(),
+		VerificationMethod: []did.VerificationMethod{*verMethod},
+		KeyAgreement:       []did.Verification{*ka},
+	}
+
+	create := func() (interface{}, error) {
+		return o.vdriRegistry.Create(o.routerDIDMethod, doc)
+	}
+
+	if o.webDID != nil {
+		opts := o.webDID.apply(doc, txnID)
+
+		create = func() (interface{}, error) {
+			return o.webDID.creator.Create(doc, opts...)
+		}
+	}
+
+	ctxCreate, span := o.tracer.Start(ctx, spanCreateDID, trace.WithAttributes(attrDIDMethod.String(o.routerDIDMethod)))
+
+	docResolution, err := withContextResult(ctxCreate, create)
+
+	endSpan(span, err)
+	if err != nil {
+		code := ErrCodeDIDCreation
+		if isTransientErr(err) {
+			code = ErrCodeDIDCreationUnavailable
+		}
+
+		return nil, withCode(code, fmt.Errorf("failed to create %s did: %w", o.routerDIDMethod, err))
+	}
+
+	return docResolution.(*did.DocResolution).DIDDocument, nil
+}
+
+// notify runs the callback in a new goroutine, so it doesn't hold up the reply. It must only be called from a
+// dispatched message handler, an exported handler or the txn sweeper, which keep the routines counter above zero
+// while Close may be waiting on it.
+func (o *Service) notify(callback func()) {
+	o.routines.Add(1)
+
+	go func() {
+		defer o.routines.Done()
+
+		_ = callHook("callback", callback)
+	}()
+}
+
+// callHook calls the user supplied hook outside of a message handler, where a panic would crash the process. A
+// panic is logged with its stack and returned as an error.
+func callHook(name string, hook func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logFields{}.with(logFieldStack, string(debug.Stack())).errorf("%s panic : %v", name, r)
+
+			err = fmt.Errorf("%s panic : %v", name, r)
+		}
+	}()
+
+	hook()
+
+	return nil
+}
+
+const (
+	ed25519VerificationKey2018 = "Ed25519VerificationKey2018"
+	x25519KeyAgreementKey2019  = "X25519KeyAgreementKey2019"
+	jsonWebKey2020             = "JsonWebKey2020"
+)
+
+// TODO: copied from mediator, should push shared code upstream
+func (o *Service) newVerificationMethod(kt kms.KeyType) (*did.VerificationMethod, error) {
+	kid, pkBytes, err := o.keyManager.CreateAndExportPubKeyBytes(kt)
+	if err != nil {
+		return nil, fmt.Errorf("creating public key: %w", err)
+	}
+
+	id := "#" + kid
+
+	var vm *did.VerificationMethod
+
+	switch kt { // nolint:exhaustive // most cases can use the default.
+	case kms.ED25519Type:
+		vm = did.NewVerificationMethodFromBytes(id, ed25519VerificationKey2018, "", pkBytes)
+	case kms.X25519ECDHKWType:
+		key := &ariescrypto.PublicKey{}
+
+		err = json.Unmarshal(pkBytes, key)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal X25519 key: %w", err)
+		}
+
+		vm = did.NewVerificationMethodFromBytes(id, x25519KeyAgreementKey2019, "", key.X)
+	default:
+		j, err := jwkkid.BuildJWK(pkBytes, kt)
+		if err != nil {
+			return nil, fmt.Errorf("creating jwk: %w", err)
+		}
+
+		j.KeyID = kid
+
+		vm, err = did.NewVerificationMethodFromJWK(id, jsonWebKey2020, "", j)
+		if err != nil {
+			return nil, fmt.Errorf("creating verification method: %w", err)
+		}
+	}
+
+	return vm, nil
+}
+
+func (o *Service) handleRouteRegistration(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	ctx, span := o.tracer.Start(ctx, spanRegisterRouteReq, msgSpanAttrs(msg.DIDCommMsg))
+
+	resp, err := o.registerRoute(ctx, msg)
+
+	endSpan(span, err)
+
+	return resp, err
+}
+
+//nolint:gocyclo,cyclop,funlen
+func (o *Service) registerRoute(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	if o.msgName(msg.DIDCommMsg.Type()) != registerRouteReqName {
+		return nil, withCode(ErrCodeUnsupportedMsgType, fmt.Errorf("unexpected message type %s, expected %s",
+			msg.DIDCommMsg.Type(), RegisterRouteReqMsgType))
+	}
+
+	err := checkConnReqFields(msg.DIDCommMsg)
+	if err != nil {
+		return nil, withCode(ErrCodeMsgParse, fmt.Errorf("parse didcomm message : %w", err))
+	}
+
+	pMsg := ConnReq{}
+
+	err = msg.DIDCommMsg.Decode(&pMsg)
+	if err != nil {
+		return nil, withCode(ErrCodeMsgParse, fmt.Errorf("parse didcomm message : %w", err))
+	}
+
+	if pMsg.ID == "" {
+		return nil, withCode(ErrCodeMsgParse, errors.New("message id mandatory"))
+	}
+
+	if msg.DIDCommMsg.ParentThreadID() == "" {
+		return nil, withCode(ErrCodeParentThreadIDMissing, errors.New("parent thread id mandatory"))
+	}
+
+	if pMsg.Data == nil {
+		return nil, withCode(ErrCodeDIDDocMissing, errors.New("data mandatory"))
+	}
+
+	if pMsg.Data.TTL < 0 {
+		return nil, withCode(ErrCodeMsgParse, errors.New("ttl must not be negative"))
+	}
+
+	if pMsg.Data.DIDDoc == nil {
+		return nil, withCode(ErrCodeDIDDocMissing, errors.New("did document mandatory"))
+	}
+
+	if isEmptyDIDDoc(pMsg.Data.DIDDoc) {
+		return nil, withCode(ErrCodeDIDDocMissing, errors.New("did document must not be empty"))
+	}
+
+	if len(pMsg.Data.DIDDoc) > o.maxDIDDocSize {
+		return nil, withCode(ErrCodeDIDDocTooLarge, fmt.Errorf("did document size %d exceeds the limit of %d bytes",
+			len(pMsg.Data.DIDDoc), o.maxDIDDocSize))
+	}
+
+	didDoc, err := o.didDocs.parse(pMsg.Data.DIDDoc)
+	if err != nil {
+		return nil, withCode(ErrCodeDIDDocInvalid, fmt.Errorf("parse did doc : %w", err))
+	}
+
+	err = validateDIDDoc(didDoc, returnRoute(msg.DIDCommMsg))
+	if err != nil {
+		return nil, withCode(ErrCodeDIDDocInvalid, fmt.Errorf("validate did doc : %w", err))
+	}
+
+	err = o.checkDIDMethod(didDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.proofVerifier != nil {
+		err = verifyDIDDocProof(o.proofVerifier, didDoc)
+		if err != nil {
+			return nil, withCode(ErrCodeDIDDocUnverified, fmt.Errorf("verify did doc proof : %w", err))
+		}
+	}
+
+	if o.transformDIDDoc != nil {
+		didDoc, err = o.transformIncomingDIDDoc(pMsg.Data.DIDDoc)
+		if err != nil {
+			return nil, withCode(ErrCodeDIDDocTransform, fmt.Errorf("transform did doc : %w", err))
+		}
+
+		// the transformed doc is held to the same rules as the client's
+		err = validateDIDDoc(didDoc, returnRoute(msg.DIDCommMsg))
+		if err != nil {
+			return nil, withCode(ErrCodeDIDDocInvalid, fmt.Errorf("validate transformed did doc : %w", err))
+		}
+
+		err = o.checkDIDMethod(didDoc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// concurrent requests with the same parent thread id would each create a connection: the first one registers
+	// the route, later ones find it registered
+	if !pMsg.Data.DryRun {
+		if _, inProgress := o.registering.LoadOrStore(msg.DIDCommMsg.ParentThreadID(), struct{}{}); inProgress {
+			return nil, withCode(ErrCodeRouteRegistered, fmt.Errorf(
+				"route registration in progress for parent thread id %s", msg.DIDCommMsg.ParentThreadID()))
+		}
+
+		defer o.registering.Delete(msg.DIDCommMsg.ParentThreadID())
+	}
+
+	// the register-route-req is correlated with its diddoc-req by the parent thread id, which must be the
+	// diddoc-req id
+	txnKey, txnBytes, err := o.getTxn(ctx, msg.DIDCommMsg.ParentThreadID())
+	if errors.Is(err, storage.ErrDataNotFound) && o.isRegistered(ctx, msg.DIDCommMsg.ParentThreadID()) {
+		// a replay of the request gets the same reply, not a request with another did doc
+		routerConnID, ok := o.registeredRoute(ctx, msg.DIDCommMsg.ParentThreadID(), pMsg.Data.DIDDoc)
+		if ok && !pMsg.Data.DryRun {
+			msgLogFields(msg).with(logFieldRouterConnectionID, routerConnID).infof("replayed route registration")
+
+			return o.connResp(msg, &ConnRespData{
+				ConnectionID:     routerConnID,
+				RoutingEndpoints: o.routingEndpoints(pMsg.Data),
+			}), nil
+		}
+
+		return nil, withCode(ErrCodeRouteRegistered, fmt.Errorf("route already registered for parent thread id %s",
+			msg.DIDCommMsg.ParentThreadID()))
+	}
+
+	if errors.Is(err, storage.ErrDataNotFound) {
+		// the txn expired, was never created or was lost with a transient store
+		return nil, withCode(ErrCodeTxnNotFound, fmt.Errorf(
+			"transaction expired, restart flow with a new diddoc-req : no diddoc-req found for parent thread "+
+				"id %s, it must be the id of the diddoc-req : %w", msg.DIDCommMsg.ParentThreadID(), err))
+	}
+
+	if err != nil {
+		return nil, withCode(ErrCodeTxnFetch, fmt.Errorf("fetch txn data : %w", err))
+	}
+
+	err = o.checkAdapterDID(ctx, didDoc, parseTxnData(txnBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if pMsg.Data.DryRun {
+		msgLogFields(msg).debugf("route registration dry run")
+
+		return o.connResp(msg, &ConnRespData{RoutingEndpoints: o.routingEndpoints(pMsg.Data), DryRun: true}), nil
+	}
+
+	_, span := o.tracer.Start(ctx, spanCreateConnection)
+
+	connOpts := append([]didexchange.ConnectionOption(nil), o.connOpts...)
+
+	if label := o.connLabel(pMsg.Data, didDoc); label != "" {
+		connOpts = append(connOpts, didexchange.WithTheirLabel(label))
+	}
+
+	routerConnID, err := o.existingConnection(didDoc.ID)
+	if err == nil && routerConnID == "" {
+		routerConnID, err = o.didExchange.CreateConnection(parseTxnData(txnBytes).DID, didDoc, connOpts...)
+	}
+
+	if err != nil {
+		err = withCode(ErrCodeConnectionCreation, fmt.Errorf("create connection : %w", err))
+	}
+
+	endSpan(span, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attrRouterConnectionID.String(routerConnID))
+
+	ctxRegister, span := o.tracer.Start(ctx, spanRegisterRoute)
+
+	err = retry(ctxRegister, o.registerRetry, o.done, isRetryableRegisterErr, func() error {
+		return withContext(ctxRegister, func() error {
+			return o.mediator.Register(routerConnID)
+		})
+	})
+	if err != nil {
+		err = withCode(ErrCodeRouteRegistration, fmt.Errorf("route registration : %w", err))
+	}
+
+	endSpan(span, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	connID, err := o.connectionLookup.GetConnectionIDByDIDs(msg.MyDID, msg.TheirDID)
+	if err != nil {
+		return nil, withCode(ErrCodeConnectionLookup, fmt.Errorf("get connection by dids : %w", err))
+	}
+
+	err = o.withStore(ctx, func() error {
+		return o.store.Put(connID, []byte(routerConnID))
+	})
+	if err != nil {
+		return nil, withCode(ErrCodeConnectionMappingSave, fmt.Errorf("save connID to routerConnID mapping : %w", err))
+	}
+
+	err = o.saveRouteExpiry(ctx, connID, routerConnID, pMsg.Data.TTL)
+	if err != nil {
+		return nil, withCode(ErrCodeConnectionMappingSave, err)
+	}
+
+	// written once the mapping is saved, a request failing before can be retried with the txn. The marker expires
+	// with the txns, a replayed request gets the same reply until then.
+	err = o.saveRegistration(ctx, msg.DIDCommMsg.ParentThreadID(), routerConnID, pMsg.Data.DIDDoc)
+	if err != nil {
+		msgLogFields(msg).withErr(err).warnf("save registration marker")
+	}
+
+	err = withContext(ctx, func() error {
+		return o.store.Delete(txnKey)
+	})
+	if err != nil {
+		msgLogFields(msg).withErr(err).warnf("delete txn data")
+	} else {
+		o.pendingTxns.remove(txnKey)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attrConnectionID.String(connID))
+
+	msgLogFields(msg).with(logFieldConnectionID, connID).with(logFieldRouterConnectionID, routerConnID).
+		infof("route registered")
+
+	if o.onRouteRegistered != nil {
+		o.notify(func() { o.onRouteRegistered(routerConnID, didDoc) })
+	}
+
+	return o.connResp(msg, &ConnRespData{
+		ConnectionID:     routerConnID,
+		RoutingEndpoints: o.routingEndpoints(pMsg.Data),
+	}), nil
+}
+
+// existingConnection returns the id of a connection to the client DID when existing connections are reused, or
+// an empty id if there is none.
+func (o *Service) existingConnection(theirDID string) (string, error) {
+	if o.connections == nil {
+		return "", nil
+	}
+
+	conns, err := o.connections.QueryConnections(&didexchange.QueryConnectionsParams{TheirDID: theirDID})
+	if err != nil {
+		return "", fmt.Errorf("query connections : %w", err)
+	}
+
+	for _, conn := range conns {
+		if conn.Record != nil && conn.TheirDID == theirDID {
+			return conn.ConnectionID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// transformIncomingDIDDoc calls the transform hook on its own parse of the did doc, leaving the cached one as is.
+func (o *Service) transformIncomingDIDDoc(raw []byte) (*did.Doc, error) {
+	didDoc, err := did.ParseDocument(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse did doc : %w", err)
+	}
+
+	didDoc, err = o.transformDIDDoc(didDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	if didDoc == nil {
+		return nil, errors.New("no did doc returned")
+	}
+
+	return didDoc, nil
+}
+
+// routingEndpoints returns the endpoints of the adapter in the order preferred by the client: the hinted one
+// first, then the ones with a preferred URL scheme and then the others, each in the configured order.
+func (o *Service) routingEndpoints(data *ConnReqData) []string {
+	if data.EndpointHint == "" && len(data.Protocols) == 0 {
+		return o.endpoints
+	}
+
+	rank := func(endpoint string) int {
+		if endpoint == data.EndpointHint {
+			return 0
+		}
+
+		if u, err := url.Parse(endpoint); err == nil {
+			for i, protocol := range data.Protocols {
+				if strings.EqualFold(u.Scheme, protocol) {
+					return i + 1
+				}
+			}
+		}
+
+		return len(data.Protocols) + 1
+	}
+
+	endpoints := append([]string(nil), o.endpoints...)
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return rank(endpoints[i]) < rank(endpoints[j])
+	})
+
+	return endpoints
+}
+
+// connLabel returns the label of the connection to the client, the one in the request or else the configured one.
+func (o *Service) connLabel(data *ConnReqData, theirDID *did.Doc) string {
+	if data.Label != "" || o.connectionLabel == nil {
+		return data.Label
+	}
+
+	return o.connectionLabel(theirDID)
+}
+
+// connResp returns the register-route-resp to the request, in the thread of the request.
+func (o *Service) connResp(msg message.Msg, data *ConnRespData) service.DIDCommMsgMap {
+	thid, err := msg.DIDCommMsg.ThreadID()
+	if err != nil {
+		thid = msg.DIDCommMsg.ID()
+	}
+
+	return service.NewDIDCommMsgMap(&ConnResp{
+		ID:   o.newID(),
+		Type: RegisterRouteRespMsgType,
+		Thread: &decorator.Thread{
+			ID:  thid,
+			PID: msg.DIDCommMsg.ParentThreadID(),
+		},
+		Data: data,
+	})
+}
+
+// handleUnregisterRoute tears down the route registered by a register-route-req on the same connection.
+func (o *Service) handleUnregisterRoute(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	ctx, span := o.tracer.Start(ctx, spanUnregisterRouteReq, msgSpanAttrs(msg.DIDCommMsg))
+
+	resp, err := o.unregisterRoute(ctx, msg)
+
+	endSpan(span, err)
+
+	return resp, err
+}
+
+func (o *Service) unregisterRoute(ctx context.Context, msg message.Msg) (service.DIDCommMsgMap, error) {
+	connID, err := o.connectionLookup.GetConnectionIDByDIDs(msg.MyDID, msg.TheirDID)
+	if err != nil {
+		return nil, withCode(ErrCodeConnectionLookup, fmt.Errorf("get connection by dids : %w", err))
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attrConnectionID.String(connID))
+
+	routerConnID, err := o.storeGet(ctx, connID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, withCode(ErrCodeRouteNotFound, fmt.Errorf("no route registered for connection %s", connID))
+	}
+
+	if err != nil {
+		return nil, withCode(ErrCodeTxnFetch, fmt.Errorf("get conn id to router conn id mapping : %w", err))
+	}
+
+	err = o.mediator.Unregister(string(routerConnID))
+	if err != nil {
+		return nil, withCode(ErrCodeRouteUnregistration, fmt.Errorf("route unregistration : %w", err))
+	}
+
+	// the route is gone, GetDIDDoc no longer serves it
+	err = withContext(ctx, func() error {
+		return o.store.Delete(connID)
+	})
+	if err != nil {
+		msgLogFields(msg).withErr(err).warnf("delete conn id to router conn id mapping")
+	}
+
+	err = withContext(ctx, func() error {
+		return o.store.Delete(routeExpiryKey(connID))
+	})
+	if err != nil {
+		msgLogFields(msg).withErr(err).warnf("delete route expiry")
+	}
+
+	msgLogFields(msg).with(logFieldConnectionID, connID).with(logFieldRouterConnectionID, string(routerConnID)).
+		infof("route unregistered")
+
+	thid, err := msg.DIDCommMsg.ThreadID()
+	if err != nil {
+		thid = msg.DIDCommMsg.ID()
+	}
+
+	return service.NewDIDCommMsgMap(&UnregisterRouteResp{
+		ID:     o.newID(),
+		Type:   UnregisterRouteRespMsgType,
+		Thread: &decorator.Thread{ID: thid},
+		Data:   &UnregisterRouteRespData{ConnectionID: string(routerConnID)},
+	}), nil
+}
+
+// checkConnReqFields checks the types of the register-route-req fields. DIDCommMsg.Decode converts mismatched
+// types where it can, eg. a number id into a string, or fails with an error that doesn't name the field.
+func checkConnReqFields(msg service.DIDCommMsg) error {
+	m := msg.Clone()
+
+	err := checkString("@id", m["@id"])
+	if err != nil {
+		return err
+	}
+
+	err = checkObject("data", m["data"])
+	if err != nil {
+		return err
+	}
+
+	if data, ok := m["data"].(map[string]interface{}); ok {
+		err = checkBool("data.dryRun", data["dryRun"])
+		if err != nil {
+			return err
+		}
+
+		err = checkString("data.label", data["label"])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkObject("~thread", m["~thread"])
+	if err != nil {
+		return err
+	}
+
+	if thread, ok := m["~thread"].(map[string]interface{}); ok {
+		return checkString("~thread.pthid", thread["pthid"])
+	}
+
+	return nil
+}
+
+// checkString checks that the field, if set, is a string.
+func checkString(field string, value interface{}) error {
+	if _, ok := value.(string); value != nil && !ok {
+		return fmt.Errorf("field %s must be a string, not %T", field, value)
+	}
+
+	return nil
+}
+
+// checkBool checks that the field, if set, is a boolean.
+func checkBool(field string, value interface{}) error {
+	if _, ok := value.(bool); value != nil && !ok {
+		return fmt.Errorf("field %s must be a boolean, not %T", field, value)
+	}
+
+	return nil
+}
+
+// checkObject checks that the field, if set, is an object.
+func checkObject(field string, value interface{}) error {
+	if _, ok := value.(map[string]interface{}); value != nil && !ok {
+		return fmt.Errorf("field %s must be an object, not %T", field, value)
+	}
+
+	return nil
+}
+
+// isRetryableRegisterErr reports whether the route registration may succeed if tried again; it can't when the
+// connection to the router is gone.
+func isRetryableRegisterErr(err error) bool {
+	return !errors.Is(err, mediatorsvc.ErrConnectionNotFound)
+}
+
+// withStore runs the txn store operation fn with the context, retrying it on transient errors as per the store
+// retry policy.
+func (o *Service) withStore(ctx context.Context, fn func() error) error {
+	return retry(ctx, o.storeRetry, o.done, isRetryableStoreErr, func() error {
+		return withContext(ctx, fn)
+	})
+}
+
+// storeGet gets the value at the key from the txn store like withStore runs an operation.
+func (o *Service) storeGet(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+
+	err := retry(ctx, o.storeRetry, o.done, isRetryableStoreErr, func() error {
+		// the value is handed over, not written by the store operation, which may outlive the context
+		v, err := withContextResult(ctx, func() (interface{}, error) {
+			return o.store.Get(key)
+		})
+		if err != nil {
+			return err
+		}
+
+		value = v.([]byte)
+
+		return nil
+	})
+
+	return value, err
+}
+
+// isRetryableStoreErr reports whether the store error is transient. A done context is not retried.
+func isRetryableStoreErr(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	return isTransientErr(err)
+}
+
+// transportQueueEndpoint is the aries endpoint of an agent without inbound transport, messages to it are queued
+// for return routes.
+const transportQueueEndpoint = "didcomm:transport/queue"
+
+// validateServiceEndpoint checks that the endpoint is an absolute URL the router did doc can be reached at.
+func validateServiceEndpoint(endpoint string) error {
+	if strings.TrimSpace(endpoint) == "" {
+		return errors.New("must not be empty")
+	}
+
+	if endpoint == transportQueueEndpoint {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("parse %s : %w", endpoint, err)
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%s is not an absolute url", endpoint)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		return nil
+	default:
+		return fmt.Errorf("%s : unsupported scheme %s, expected http, https, ws or wss", endpoint, u.Scheme)
+	}
+}
+
+// routerServices returns the did-communication services of a new router did doc.
+func (o *Service) routerServices() []did.Service {
+	services := make([]did.Service, len(o.endpoints))
+
+	for i, endpoint := range o.endpoints {
+		services[i] = did.Service{
+			Type:            didCommServiceType,
+			Priority:        o.routerService.Priority,
+			RecipientKeys:   copyStrings(o.routerService.RecipientKeys),
+			RoutingKeys:     copyStrings(o.routerService.RoutingKeys),
+			ServiceEndpoint: model.NewDIDCommV1Endpoint(endpoint),
+			Accept:          copyStrings(o.routerService.Accept),
+		}
+
+		// the did doc only marshals accept of DIDComm V2 endpoints, add it to the did-communication service as is
+		if len(o.routerService.Accept) > 0 {
+			services[i].Properties = map[string]interface{}{"accept": copyStrings(o.routerService.Accept)}
+		}
+	}
+
+	return services
+}
+
+// copyStrings returns a copy of s, so services created from the same template don't share their slices.
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string(nil), s...)
+}
+
+// txnKey returns the txn store key of the diddoc-req transaction. The key is namespaced, so it can't collide
+// with the connection id mappings kept in the same store.
+func txnKey(txnID string) string {
+	return txnKeyPrefix + txnID
+}
+
+// registeredKey returns the txn store key of the marker saved once the route for the diddoc-req transaction is
+// registered.
+func registeredKey(txnID string) string {
+	return registeredKeyPrefix + txnID
+}
+
+// routerDIDKey returns the txn store key of the marker saved for each router did created, holding the id of its
+// diddoc-req. The marker is durable, it is not deleted with the expired txns.
+func routerDIDKey(routerDID string) string {
+	return routerDIDKeyPrefix + routerDID
+}
+
+// checkAdapterDID rejects the did doc of a register-route-req if its DID is one of the adapter's: the router did
+// of the transaction or of any other one created, or one under the adapter did:web. The connection would
+// be to the adapter itself.
+func (o *Service) checkAdapterDID(ctx context.Context, didDoc *did.Doc, txn *txnData) error {
+	if didDoc.ID == txn.DID || (o.webDID != nil && o.webDID.owns(didDoc.ID)) {
+		return withCode(ErrCodeAdapterDID, fmt.Errorf("did %s is the adapter's, not the client's", didDoc.ID))
+	}
+
+	err := o.withStore(ctx, func() error {
+		_, errGet := o.store.Get(routerDIDKey(didDoc.ID))
+
+		return errGet
+	})
+
+	switch {
+	case err == nil:
+		return withCode(ErrCodeAdapterDID, fmt.Errorf("did %s is an adapter router did, not the client's", didDoc.ID))
+	case errors.Is(err, storage.ErrDataNotFound):
+		return nil
+	default:
+		return withCode(ErrCodeTxnFetch, fmt.Errorf("fetch router did : %w", err))
+	}
+}
+
+// msgExpiry returns the expires_time of the ~timing decorator of the message, zero if there is none.
+func msgExpiry(msg service.DIDCommMsg) (time.Time, error) {
+	msgMap, ok := msg.(service.DIDCommMsgMap)
+	if !ok || msgMap["~timing"] == nil {
+		return time.Time{}, nil
+	}
+
+	timing := struct {
+		Timing *decorator.Timing `json:"~timing,omitempty"`
+	}{}
+
+	err := msgMap.Decode(&timing)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if timing.Timing == nil {
+		return time.Time{}, nil
+	}
+
+	return timing.Timing.ExpiresTime, nil
+}
+
+// checkDIDMethod rejects the did doc if its DID method is not one of the allowed ones.
+func (o *Service) checkDIDMethod(didDoc *did.Doc) error {
+	if len(o.allowedDIDMethods) == 0 {
+		return nil
+	}
+
+	parsed, err := did.Parse(didDoc.ID)
+	if err != nil {
+		return withCode(ErrCodeDIDMethodNotAllowed, fmt.Errorf("did method policy : parse did %s : %w", didDoc.ID, err))
+	}
+
+	for _, method := range o.allowedDIDMethods {
+		if parsed.Method == method {
+			return nil
+		}
+	}
+
+	return withCode(ErrCodeDIDMethodNotAllowed, fmt.Errorf("did method policy : did method %s is not allowed, "+
+		"expected one of %s", parsed.Method, strings.Join(o.allowedDIDMethods, ", ")))
+}
+
+// registration is the registration marker of a diddoc-req transaction, with the client did doc in canonical form.
+type registration struct {
+	RouterConnID string          `json:"routerConnID"`
+	DIDDoc       json.RawMessage `json:"didDoc"`
+}
+
+// saveRegistration saves the registration marker of the route registered for the diddoc-req transaction.
+func (o *Service) saveRegistration(ctx context.Context, txnID, routerConnID string, didDoc []byte) error {
+	// canonical so that a replay of the request matches whatever the key order of the client's marshaler
+	canonicalDoc, err := message.CanonicalJSON(didDoc)
+	if err != nil {
+		return fmt.Errorf("canonicalize did doc : %w", err)
+	}
+
+	regBytes, err := json.Marshal(&registration{RouterConnID: routerConnID, DIDDoc: canonicalDoc})
+	if err != nil {
+		return fmt.Errorf("marshal registration : %w", err)
+	}
+
+	return o.withStore(ctx, func() error {
+		return o.store.Put(registeredKey(txnID), regBytes, o.txnCreatedTag())
+	})
+}
+
+// registeredRoute returns the router connection id of the route registered for the diddoc-req transaction if it
+// was registered with the did doc, compared in canonical form. Store errors are reported as another did doc.
+func (o *Service) registeredRoute(ctx context.Context, txnID string, didDoc []byte) (string, bool) {
+	regBytes, err := o.storeGet(ctx, registeredKey(txnID))
+	if err != nil {
+		return "", false
+	}
+
+	reg := &registration{}
+
+	// markers saved before the did doc was recorded hold the router connection id only
+	if json.Unmarshal(regBytes, reg) != nil || reg.DIDDoc == nil {
+		return "", false
+	}
+
+	// the stored doc is canonicalized again, the marker marshaling escapes HTML characters
+	storedDoc, err := message.CanonicalJSON(reg.DIDDoc)
+	if err != nil {
+		return "", false
+	}
+
+	canonicalDoc, err := message.CanonicalJSON(didDoc)
+	if err != nil || !bytes.Equal(canonicalDoc, storedDoc) {
+		return "", false
+	}
+
+	return reg.RouterConnID, true
+}
+
+// isRegistered reports whether the route for the diddoc-req transaction has been registered. Store errors are
+// reported as not registered.
+func (o *Service) isRegistered(ctx context.Context, txnID string) bool {
+	return o.withStore(ctx, func() error {
+		_, err := o.store.Get(registeredKey(txnID))
+
+		return err
+	}) == nil
+}
+
+// getTxn returns the diddoc-req transaction data and the key it is stored at. Only the namespaced key is looked
+// up: the id comes from the client, at any other key it could name an entry that is not a transaction.
+func (o *Service) getTxn(ctx context.Context, txnID string) (string, []byte, error) {
+	key := txnKey(txnID)
+
+	txnBytes, err := o.storeGet(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, txnBytes, nil
+}
+
+// txnData is the diddoc-req transaction data, ie. the router did created for the transaction.
+type txnData struct {
+	DID    string          `json:"did"`
+	DIDDoc json.RawMessage `json:"didDoc,omitempty"`
+}
+
+// parseTxnData parses the stored txn data. Transactions saved before the did doc was stored hold only the did.
+func parseTxnData(b []byte) *txnData {
+	txn := &txnData{}
+
+	err := json.Unmarshal(b, txn)
+	if err != nil || txn.DID == "" {
+		return &txnData{DID: string(b)}
+	}
+
+	return txn
+}
+
+// isEmptyDIDDoc reports whether the raw did doc carries no document, ie. it is blank, null or an empty string.
+func isEmptyDIDDoc(doc json.RawMessage) bool {
+	switch string(bytes.TrimSpace(doc)) {
+	case "", "null", `""`:
+		return true
+	default:
+		return false
+	}
+}
+
+// returnRoute reports whether the sender asked for the replies on the inbound transport, eg. a websocket client
+// without an endpoint of its own. The aries websocket transport then keeps the connection for its keys and the
+// replies go back on it.
+func returnRoute(msg service.DIDCommMsg) bool {
+	t := &decorator.Transport{}
+
+	err := msg.Decode(t)
+	if err != nil || t.ReturnRoute == nil {
+		return false
+	}
+
+	switch t.ReturnRoute.Value {
+	case decorator.TransportReturnRouteAll, decorator.TransportReturnRouteThread:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateDIDDoc checks that the did doc can receive messages, ie. it has a didcomm service with an endpoint and
+// at least one recipient key. The transport queue endpoint of a client without inbound transport is only reachable
+// on the inbound transport, which requires the sender to ask for return route.
+func validateDIDDoc(doc *did.Doc, returnRoute bool) error {
+	svc, ok := did.LookupService(doc, didCommServiceType)
+	if !ok {
+		svc, ok = did.LookupService(doc, didCommV2ServiceType)
+		if !ok {
+			return fmt.Errorf("missing %s or %s service", didCommServiceType, didCommV2ServiceType)
+		}
+	}
+
+	uri, err := svc.ServiceEndpoint.URI()
+	if err != nil || uri == "" {
+		return fmt.Errorf("missing service endpoint in %s service", svc.Type)
+	}
+
+	if uri == transportQueueEndpoint && !returnRoute {
+		return fmt.Errorf("service endpoint %s is only reachable with return route", uri)
+	}
+
+	if len(svc.RecipientKeys) == 0 && len(doc.KeyAgreement) == 0 && len(doc.Authentication) == 0 {
+		return errors.New("missing recipient key")
+	}
+
+	return nil
+}
+
+// txnSweeper deletes the expired txns and unregisters the expired routes every txn TTL, or by the expiry of the
+// next route to expire if it is sooner.
+func (o *Service) txnSweeper() {
+	wait := o.sweepWait()
+	next := time.Now().Add(wait)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			err := o.deleteExpiredTxns(o.clock.Now())
+			if err != nil {
+				logFields{}.withErr(err).warnf("delete expired txn data")
+			}
+
+			err = o.unregisterExpiredRoutes(o.clock.Now())
+			if err != nil {
+				logFields{}.withErr(err).warnf("unregister expired routes")
+			}
+
+			wait = o.sweepWait()
+			next = time.Now().Add(wait)
+			timer.Reset(wait)
+		case <-o.routeExpiryCh:
+			wait = o.sweepWait()
+			if !time.Now().Add(wait).Before(next) {
+				continue
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+
+			next = time.Now().Add(wait)
+			timer.Reset(wait)
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// sweepWait returns the wait until the next sweep: the txn TTL, or less if a route expires before.
+func (o *Service) sweepWait() time.Duration {
+	wait := o.txnTTL
+
+	o.routeExpiryMu.Lock()
+	nextExpiry := o.nextRouteExpiry
+	o.routeExpiryMu.Unlock()
+
+	if !nextExpiry.IsZero() && nextExpiry.Sub(o.clock.Now()) < wait {
+		wait = nextExpiry.Sub(o.clock.Now())
+	}
+
+	if wait < minSweepWait {
+		wait = minSweepWait
+	}
+
+	return wait
+}
+
+// deleteExpiredTxns removes the diddoc-req transactions created more than txnTTL before now.
+func (o *Service) deleteExpiredTxns(now time.Time) error {
+	expired, err := o.expiredTxns(now)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		err = o.store.Delete(key)
+		if err != nil {
+			return fmt.Errorf("delete txn %s : %w", key, err)
+		}
+
+		o.pendingTxns.remove(key)
+	}
+
+	return nil
+}
+
+func (o *Service) expiredTxns(now time.Time) ([]string, error) {
+	txns, err := o.storedTxns()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+
+	for _, txn := range txns {
+		if now.Sub(txn.created) > o.txnTTL {
+			expired = append(expired, txn.key)
+		}
+	}
+
+	return expired, nil
+}
+
+// storedTxn is a txn store entry removed once it expires.
+type storedTxn struct {
+	key     string
+	created time.Time
+}
+
+// storedTxns returns the txn store entries tagged with their creation time, ie. the diddoc-req transactions and
+// the registration markers.
+func (o *Service) storedTxns() ([]storedTxn, error) {
+	iter, err := o.store.Query(txnCreatedTagName)
+	if err != nil {
+		return nil, fmt.Errorf("query txn data : %w", err)
+	}
+
+	defer func() {
+		errClose := iter.Close()
+		if errClose != nil {
+			logFields{}.withErr(errClose).warnf("close txn iterator")
+		}
+	}()
+
+	var txns []storedTxn
+
+	for {
+		ok, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("iterate txn data : %w", err)
+		}
+
+		if !ok {
+			return txns, nil
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return nil, fmt.Errorf("get txn key : %w", err)
+		}
+
+		tags, err := iter.Tags()
+		if err != nil {
+			return nil, fmt.Errorf("get txn tags : %w", err)
+		}
+
+		created, err := txnCreated(tags)
+		if err != nil {
+			return nil, fmt.Errorf("txn %s : %w", key, err)
+		}
+
+		txns = append(txns, storedTxn{key: key, created: created})
+	}
+}
+
+// routeExpiryKey returns the txn store key of the expiry of the route registered with a TTL for the connection,
+// holding the router connection id.
+func routeExpiryKey(connID string) string {
+	return routeExpiryKeyPrefix + connID
+}
+
+// saveRouteExpiry saves the expiry of the route registered with a TTL in seconds, so the sweeper unregisters it
+// once it expires. Without a TTL the expiry of an earlier registration on the connection is deleted, the route
+// doesn't expire.
+func (o *Service) saveRouteExpiry(ctx context.Context, connID, routerConnID string, ttl int64) error {
+	if ttl == 0 {
+		_, err := o.storeGet(ctx, routeExpiryKey(connID))
+		if errors.Is(err, storage.ErrDataNotFound) {
+			return nil
+		}
+
+		if err == nil {
+			err = o.withStore(ctx, func() error {
+				return o.store.Delete(routeExpiryKey(connID))
+			})
+		}
+
+		if err != nil {
+			return fmt.Errorf("delete route expiry : %w", err)
+		}
+
+		return nil
+	}
+
+	expires := o.clock.Now().Add(time.Duration(ttl) * time.Second)
+
+	err := o.withStore(ctx, func() error {
+		return o.store.Put(routeExpiryKey(connID), []byte(routerConnID), storage.Tag{
+			Name:  routeExpiresTagName,
+			Value: strconv.FormatInt(expires.UnixNano(), 10),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("save route expiry : %w", err)
+	}
+
+	o.routeExpiryMu.Lock()
+	if o.nextRouteExpiry.IsZero() || expires.Before(o.nextRouteExpiry) {
+		o.nextRouteExpiry = expires
+	}
+	o.routeExpiryMu.Unlock()
+
+	// wakes the sweeper up to run by the expiry
+	select {
+	case o.routeExpiryCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// expiredRoute is a route registered with a TTL that expired.
+type expiredRoute struct {
+	key          string
+	routerConnID string
+}
+
+// unregisterExpiredRoutes unregisters the routes registered with a TTL that expired before now, and deletes their
+// conn id to router conn id mapping. An expiry whose router connection is no longer the one mapped to the
+// connection, eg. the route was registered again since, is deleted without unregistering anything. A route that
+// fails to be unregistered is logged and tried again on the next sweep.
+func (o *Service) unregisterExpiredRoutes(now time.Time) error {
+	routes, nextExpiry, err := o.expiredRoutes(now)
+	if err != nil {
+		return err
+	}
+
+	o.routeExpiryMu.Lock()
+	if !o.nextRouteExpiry.After(now) || (!nextExpiry.IsZero() && nextExpiry.Before(o.nextRouteExpiry)) {
+		o.nextRouteExpiry = nextExpiry
+	}
+	o.routeExpiryMu.Unlock()
+
+	for _, route := range routes {
+		o.unregisterExpiredRoute(route)
+	}
+
+	return nil
+}
+
+func (o *Service) unregisterExpiredRoute(route expiredRoute) {
+	connID := strings.TrimPrefix(route.key, routeExpiryKeyPrefix)
+	fields := logFields{}.with(logFieldConnectionID, connID).with(logFieldRouterConnectionID, route.routerConnID)
+
+	mapped, err := o.store.Get(connID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		fields.withErr(err).warnf("get route of expired connection")
+
+		return
+	}
+
+	if err == nil && string(mapped) == route.routerConnID {
+		err = o.mediator.Unregister(route.routerConnID)
+		if err != nil {
+			fields.withErr(err).warnf("unregister expired route")
+
+			return
+		}
+
+		err = o.store.Delete(connID)
+		if err != nil {
+			fields.withErr(err).warnf("delete route of expired connection")
+
+			return
+		}
+
+		fields.infof("expired route unregistered")
+	}
+
+	err = o.store.Delete(route.key)
+	if err != nil {
+		fields.withErr(err).warnf("delete route expiry")
+	}
+}
+
+// expiredRoutes returns the routes expired before now, and the earliest expiry of the other ones, zero if there is
+// none.
+func (o *Service) expiredRoutes(now time.Time) ([]expiredRoute, time.Time, error) {
+	iter, err := o.store.Query(routeExpiresTagName)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("query route expiries : %w", err)
+	}
+
+	defer func() {
+		errClose := iter.Close()
+		if errClose != nil {
+			logFields{}.withErr(errClose).warnf("close route expiry iterator")
+		}
+	}()
+
+	var (
+		routes     []expiredRoute
+		nextExpiry time.Time
+	)
+
+	for {
+		ok, err := iter.Next()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("iterate route expiries : %w", err)
+		}
+
+		if !ok {
+			return routes, nextExpiry, nil
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("get route expiry key : %w", err)
+		}
+
+		tags, err := iter.Tags()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("get route expiry tags : %w", err)
+		}
+
+		expires, err := routeExpires(tags)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("route expiry %s : %w", key, err)
+		}
+
+		if expires.After(now) {
+			if nextExpiry.IsZero() || expires.Before(nextExpiry) {
+				nextExpiry = expires
+			}
+
+			continue
+		}
+
+		routerConnID, err := iter.Value()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("get route expiry value : %w", err)
+		}
+
+		routes = append(routes, expiredRoute{key: key, routerConnID: string(routerConnID)})
+	}
+}
+
+func routeExpires(tags []storage.Tag) (time.Time, error) {
+	for _, tag := range tags {
+		if tag.Name != routeExpiresTagName {
+			continue
+		}
+
+		nsec, err := strconv.ParseInt(tag.Value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse expiry time : %w", err)
+		}
+
+		return time.Unix(0, nsec), nil
+	}
+
+	return time.Time{}, errors.New("expiry time missing")
+}
+
+// checkPendingTxns makes room for a new diddoc-req transaction when MaxPendingTxns are pending, by failing or by
+// evicting the oldest ones as per the PendingTxnsPolicy. Concurrent requests may exceed the limit slightly.
+func (o *Service) checkPendingTxns() error {
+	if o.maxPendingTxns <= 0 {
+		return nil
+	}
+
+	pending := o.pendingTxns.len()
+
+	excess := pending - o.maxPendingTxns + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	if o.pendingTxnsPolicy != EvictOldestTxns {
+		return withCode(ErrCodeTooManyTxns, fmt.Errorf("%d diddoc-req transactions pending, try again later",
+			pending))
+	}
+
+	for _, key := range o.pendingTxns.oldest(excess) {
+		err := o.store.Delete(key)
+		if err != nil {
+			return withCode(ErrCodeTxnSave, fmt.Errorf("evict txn %s : %w", key, err))
+		}
+
+		o.pendingTxns.remove(key)
+	}
+
+	return nil
+}
+
+// txnCreatedTag returns the tag holding the creation time of a txn store entry, which expires txnTTL later.
+func (o *Service) txnCreatedTag() storage.Tag {
+	return storage.Tag{
+		Name:  txnCreatedTagName,
+		Value: strconv.FormatInt(o.clock.Now().UnixNano(), 10),
+	}
+}
+
+func txnCreated(tags []storage.Tag) (time.Time, error) {
+	for _, tag := range tags {
+		if tag.Name != txnCreatedTagName {
+			continue
+		}
+
+		nsec, err := strconv.ParseInt(tag.Value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse creation time : %w", err)
+		}
+
+		return time.Unix(0, nsec), nil
+	}
+
+	return time.Time{}, errors.New("creation time missing")
+}
+
+func parseSupportedVersions(supported []string) ([]protocolVersion, error) {
+	if len(supported) == 0 {
+		supported = []string{msgTypeVersion}
+	}
+
+	versions := make([]protocolVersion, len(supported))
+
+	for i, v := range supported {
+		var err error
+
+		versions[i], err = parseProtocolVersion(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return versions, nil
+}
+
+func getTxnStore(prov storage.Provider, name string) (storage.Store, error) {
+	txnStore, err := prov.OpenStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open txn store: %w", err)
+	}
+
+	err = prov.SetStoreConfig(name, storage.StoreConfiguration{TagNames: []string{txnCreatedTagName, routeExpiresTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set txn store config: %w", err)
 	}
 
 	return txnStore, nil
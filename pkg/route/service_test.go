@@ -7,25 +7,38 @@ SPDX-License-Identifier: Apache-2.0
 package route
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
 	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
 	"github.com/hyperledger/aries-framework-go/pkg/common/model"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/messaging/msghandler"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
 	mediatorsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 	mockroute "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/mediator"
 	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
 	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
 	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
 
 	"github.com/trustbloc/edge-adapter/pkg/aries/message"
 	mockconn "github.com/trustbloc/edge-adapter/pkg/internal/mock/connection"
@@ -42,7 +55,8 @@ func TestNew(t *testing.T) {
 
 		c, err := New(config())
 		require.NoError(t, err)
-		require.NotEmpty(t, c)
+		require.NotNil(t, c)
+		require.NoError(t, c.Close(context.Background()))
 	})
 
 	t.Run("store error", func(t *testing.T) {
@@ -56,27 +70,4127 @@ func TestNew(t *testing.T) {
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "store: open db error")
 	})
+
+	t.Run("generic ping service of another component", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		require.NoError(t, config.MsgRegistrar.Register(message.NewMsgSvc("ping", "https://example.com/ping", nil)))
+
+		c, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("message service registration error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		// a service of another component holds one of the names
+		require.NoError(t, config.MsgRegistrar.Register(message.NewMsgSvc(pingSvcName, "https://example.com/ping", nil)))
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "register msg services : ")
+		require.Contains(t, err.Error(), "message service client")
+
+		services := config.MsgRegistrar.Services()
+		require.Len(t, services, 1)
+		require.False(t, services[0].Accept(DIDDocReqMsgType, nil))
+
+		require.NoError(t, config.MsgRegistrar.Unregister(pingSvcName))
+
+		c, err := New(config)
+		require.NoError(t, err)
+		require.Len(t, config.MsgRegistrar.Services(), len(c.RegisteredTypes()))
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("blank router did method", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.RouterDIDMethod = " "
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "router did method must not be blank")
+	})
+
+	t.Run("blank txn store name", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.TxnStoreName = " "
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "txn store name must not be blank")
+	})
+
+	t.Run("txn store names isolate services", func(t *testing.T) {
+		t.Parallel()
+
+		provider := mem.NewProvider()
+
+		first := config()
+		first.Store = provider
+
+		c1, err := New(first)
+		require.NoError(t, err)
+
+		second := config()
+		second.Store = provider
+		second.TxnStoreName = "tenant2_txn"
+
+		c2, err := New(second)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c1.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		_, err = c1.store.Get(txnKey(txnID))
+		require.NoError(t, err)
+
+		_, err = c2.store.Get(txnKey(txnID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		// the registration for the txn of the first service fails on the second one
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c2.handleRouteRegistration(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: didDocBytes},
+			}),
+		})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+	})
+
+	t.Run("store config error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		config.Store = &mockstorage.Provider{ErrSetStoreConfig: errors.New("store config error")}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "store config error")
+	})
+
+	t.Run("missing required fields", func(t *testing.T) {
+		t.Parallel()
+
+		for field, omit := range map[string]func(*Config){
+			"DIDExchangeClient": func(c *Config) { c.DIDExchangeClient = nil },
+			"MediatorClient":    func(c *Config) { c.MediatorClient = nil },
+			"AriesMessenger":    func(c *Config) { c.AriesMessenger = nil },
+			"MsgRegistrar":      func(c *Config) { c.MsgRegistrar = nil },
+			"VDRIRegistry":      func(c *Config) { c.VDRIRegistry = nil },
+			"Store":             func(c *Config) { c.Store = nil },
+		} {
+			config := config()
+			omit(config)
+
+			_, err := New(config)
+			require.EqualError(t, err, "config : missing required fields : "+field)
+		}
+
+		_, err := New(&Config{ServiceEndpoint: "http://adapter.com"})
+		require.EqualError(t, err, "config : missing required fields : DIDExchangeClient, MediatorClient, "+
+			"AriesMessenger, MsgRegistrar, VDRIRegistry, Store")
+	})
+
+	t.Run("service endpoints", func(t *testing.T) {
+		t.Parallel()
+
+		for endpoint, expected := range map[string]string{
+			"http://adapter.com":          "",
+			"https://adapter.com/router":  "",
+			"ws://adapter.com":            "",
+			"wss://adapter.com:8443/ws":   "",
+			transportQueueEndpoint:        "",
+			"":                            "service endpoint : must not be empty",
+			"  ":                          "service endpoint : must not be empty",
+			"adapter.com":                 "service endpoint : adapter.com is not an absolute url",
+			"http://":                     "service endpoint : http:// is not an absolute url",
+			"ftp://adapter.com":           "unsupported scheme ftp, expected http, https, ws or wss",
+			"http://adapter.com/%zz":      "service endpoint : parse http://adapter.com/%zz",
+			"didcomm:transport/somewhere": "is not an absolute url",
+		} {
+			config := config()
+			config.ServiceEndpoint = endpoint
+
+			_, err := New(config)
+			if expected == "" {
+				require.NoError(t, err, endpoint)
+
+				continue
+			}
+
+			require.Error(t, err, endpoint)
+			require.Contains(t, err.Error(), expected, endpoint)
+		}
+	})
+
+	t.Run("invalid one of the service endpoints", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ServiceEndpoints = []string{"https://adapter.com", "adapter.com/ws"}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "adapter.com/ws is not an absolute url")
+	})
+
+	t.Run("any service endpoint", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ServiceEndpoint = "bluetooth://adapter"
+		config.AnyServiceEndpoint = true
+
+		_, err := New(config)
+		require.NoError(t, err)
+	})
 }
 
-func TestDIDCommMsgListener(t *testing.T) {
+func TestRegisteredTypes(t *testing.T) {
 	t.Parallel()
 
-	t.Run("unsupported message type", func(t *testing.T) {
+	config := config()
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	types := c.RegisteredTypes()
+	require.Equal(t, []string{
+		DIDDocReqMsgType, RegisterRouteReqMsgType, PingMsgType, UnregisterRouteReqMsgType,
+	}, types)
+
+	services := config.MsgRegistrar.Services()
+	require.Len(t, services, len(types))
+
+	for i, svc := range services {
+		for j, msgType := range types {
+			require.Equal(t, i == j, svc.Accept(msgType, nil), "%s : %s", svc.Name(), msgType)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.IDGenerator = func() string { return "probe" }
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Ready(context.Background()))
+
+		_, err = c.store.Get(readyCheckKeyPrefix + "probe")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		for expected, store := range map[string]storage.Store{
+			"txn store put : put error":       &mockstorage.Store{ErrPut: errors.New("put error")},
+			"txn store get : get error":       &mockstorage.Store{ErrGet: errors.New("get error")},
+			"txn store delete : delete error": &failingDeleteStore{Store: c.store, err: errors.New("delete error")},
+		} {
+			o := &Service{store: store, newID: uuid.New().String, done: make(chan struct{})}
+
+			require.EqualError(t, o.Ready(context.Background()), expected)
+		}
+	})
+
+	t.Run("unexpected value", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		c.store = &staleGetStore{Store: c.store}
+
+		require.EqualError(t, c.Ready(context.Background()), "txn store get : unexpected value")
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		release := make(chan struct{})
+		defer close(release)
+
+		c.store = &blockingStore{Store: c.store, release: release}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = c.Ready(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("entry deleted after the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.IDGenerator = func() string { return "late" }
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		store := c.store
+		release := make(chan struct{})
+		deleted := make(chan string, 1)
+
+		c.store = &deleteRecordingStore{Store: &blockingStore{Store: store, release: release}, deleted: deleted}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, c.Ready(ctx), context.DeadlineExceeded)
+
+		// the probe completes once the store responds again
+		close(release)
+
+		select {
+		case key := <-deleted:
+			require.Equal(t, readyCheckKeyPrefix+"late", key)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+
+		_, err = store.Get(readyCheckKeyPrefix + "late")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("entry deleted when the check fails", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.IDGenerator = func() string { return "stale" }
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		store := c.store
+		c.store = &staleGetStore{Store: store}
+
+		require.EqualError(t, c.Ready(context.Background()), "txn store get : unexpected value")
+
+		_, err = store.Get(readyCheckKeyPrefix + "stale")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		require.NoError(t, c.Close(context.Background()))
+		require.EqualError(t, c.Ready(context.Background()), "service is closed")
+	})
+}
+
+func TestClose(t *testing.T) { // nolint:paralleltest // goleak requires no concurrently running tests
+	t.Run("listener stops without leaking", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		require.NoError(t, c.Close(context.Background()))
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("cancels the service context", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		require.NoError(t, c.Close(context.Background()))
+		require.ErrorIs(t, c.ctx.Err(), context.Canceled)
+	})
+
+	t.Run("drops inbound messages after close", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		config := config()
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		svcs := config.MsgRegistrar.Services()
+		require.NotEmpty(t, svcs)
+
+		require.NoError(t, c.Close(context.Background()))
+
+		for _, svc := range svcs {
+			_, err = svc.HandleInbound(service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: DIDDocReqMsgType,
+			}), service.EmptyDIDCommContext())
+			require.ErrorIs(t, err, message.ErrMsgSvcClosed)
+		}
+	})
+
+	t.Run("exported handlers fail after close", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		require.NoError(t, c.Close(context.Background()))
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		}))
+		require.EqualError(t, err, "service is closed")
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+		})})
+		require.EqualError(t, err, "service is closed")
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(UnregisterRouteReq{
+				ID:   uuid.New().String(),
+				Type: UnregisterRouteReqMsgType,
+			}),
+		})
+		require.EqualError(t, err, "service is closed")
+	})
+
+	t.Run("waits for the callbacks of exported handler calls concurrent with close", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		var closed, late, created int32
+
+		config := config()
+		config.OnDIDDocCreated = func(*did.Doc) {
+			time.Sleep(time.Millisecond)
+
+			if atomic.LoadInt32(&closed) == 1 {
+				atomic.AddInt32(&late, 1)
+			}
+
+			atomic.AddInt32(&created, 1)
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for {
+					_, errHandle := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+						ID:   uuid.New().String(),
+						Type: DIDDocReqMsgType,
+					}))
+					if errHandle != nil {
+						require.EqualError(t, errHandle, "service is closed")
+
+						return
+					}
+				}
+			}()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		require.NoError(t, c.Close(context.Background()))
+		atomic.StoreInt32(&closed, 1)
+
+		wg.Wait()
+
+		require.NotZero(t, atomic.LoadInt32(&created))
+		require.Zero(t, atomic.LoadInt32(&late), "callbacks ran after Close returned")
+	})
+
+	t.Run("unregisters message services", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		config := config()
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		err = c.RegisterHandler("custom-status", "https://example.com/custom/1.0/status-req",
+			func(context.Context, service.DIDCommMsg) (service.DIDCommMsgMap, error) { return nil, nil })
+		require.NoError(t, err)
+		require.Len(t, config.MsgRegistrar.Services(), len(c.RegisteredTypes()))
+
+		require.NoError(t, c.Close(context.Background()))
+		require.Empty(t, config.MsgRegistrar.Services())
+
+		// the registrar no longer routes the message types to the closed service, a new one can take them
+		c, err = New(config)
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("unregister error", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		config := config()
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.NoError(t, config.MsgRegistrar.Unregister(pingSvcName))
+
+		err = c.Close(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unregister message services : failed to unregister")
+		require.Contains(t, err.Error(), pingSvcName)
+		require.Empty(t, config.MsgRegistrar.Services())
+
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("drains queued messages", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		replied := make(chan struct{}, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replied <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		// a listener finding the message queued on close, Close waits for it before cancelling the handlers
+		c.routines.Add(1)
+
+		go func() {
+			defer c.routines.Done()
+
+			<-c.done
+			c.didCommMsgListener(msgCh)
+		}()
+
+		require.NoError(t, c.Close(context.Background()))
+
+		select {
+		case <-replied:
+		default:
+			require.Fail(t, "queued message was not processed")
+		}
+	})
+
+	t.Run("context done before listener stops", func(t *testing.T) {
+		config := config()
+
+		block := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				<-block
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer close(block)
+
+		_, err = config.MsgRegistrar.Services()[0].HandleInbound(service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		}), service.EmptyDIDCommContext())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// give the listener a chance to pick up the message
+		time.Sleep(10 * time.Millisecond)
+
+		err = c.Close(ctx)
+		require.Error(t, err)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestListenerSupervisor(t *testing.T) {
+	t.Parallel()
+
+	// supervise runs the supervisor with a listener on its own channel that fails with exit on its first run, and
+	// returns the channel, the replies, the number of listener runs and a channel closed when the supervisor returns.
+	supervise := func(t *testing.T, exit func()) (chan message.Msg, chan string, *int32, chan struct{}) {
+		t.Helper()
+
+		replies := make(chan string, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, _ service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msgID
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		stopped := make(chan struct{})
+
+		var runs int32
+
+		go func() {
+			defer close(stopped)
+
+			c.superviseListener(func() {
+				if atomic.AddInt32(&runs, 1) == 1 {
+					exit()
+
+					return
+				}
+
+				c.didCommMsgListener(msgCh)
+			})
+		}()
+
+		t.Cleanup(func() {
+			require.NoError(t, c.Close(context.Background()))
+			<-stopped
+		})
+
+		return msgCh, replies, &runs, stopped
+	}
+
+	for name, exit := range map[string]func(){
+		"listener returns": func() {},
+		"listener panics":  func() { panic("listener failure") },
+	} {
+		exit := exit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msgCh, replies, runs, stopped := supervise(t, exit)
+
+			msgID := uuid.New().String()
+			msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   msgID,
+				Type: DIDDocReqMsgType,
+			})}
+
+			select {
+			case replyTo := <-replies:
+				require.Equal(t, msgID, replyTo)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "restarted listener did not process the message")
+			}
+
+			require.EqualValues(t, 2, atomic.LoadInt32(runs))
+
+			select {
+			case <-stopped:
+				require.Fail(t, "supervisor returned before close")
+			default:
+			}
+		})
+	}
+}
+
+func TestDIDCommMsgListener(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ping", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("vdr used by ping")}
+
+		replies := make(chan service.DIDCommMsgMap, 1)
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrPut: errors.New("store used by ping"), ErrGet: errors.New("store used by ping")}
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		pingID := uuid.New().String()
+		before := time.Now().UTC()
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: pingID, Type: PingMsgType})}
+
+		select {
+		case reply := <-replies:
+			pMsg := &PingResp{}
+			require.NoError(t, reply.Decode(pMsg))
+			require.Equal(t, PingRespMsgType, pMsg.Type)
+			require.Equal(t, pingID, pMsg.Thread.ID)
+
+			timestamp, err := time.Parse(time.RFC3339Nano, pMsg.Timestamp)
+			require.NoError(t, err)
+			require.False(t, timestamp.Before(before))
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("recovers from handler panics", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				panic("create connection panic")
+			},
+		}
+
+		replies := make(chan service.DIDCommMsgMap, 2)
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 2)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})}
+
+		select {
+		case reply := <-replies:
+			pMsg := &ErrorResp{}
+			require.NoError(t, reply.Decode(pMsg))
+			require.Equal(t, RegisterRouteRespMsgType, pMsg.Type)
+			require.Equal(t, ErrCodeInternal, pMsg.Data.Code)
+			require.Equal(t, "handler panic : create connection panic", pMsg.Data.ErrorMsg)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType})}
+
+		select {
+		case reply := <-replies:
+			require.Equal(t, PingRespMsgType, reply.Type())
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "messages are not handled after a panic")
+		}
+	})
+
+	t.Run("handles messages concurrently", func(t *testing.T) {
+		t.Parallel()
+
+		const maxHandlers = 2
+
+		config := config()
+		config.MaxConcurrentHandlers = maxHandlers
+
+		release := make(chan struct{})
+		replying := make(chan struct{}, maxHandlers+1)
+
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replying <- struct{}{}
+				<-release
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, maxHandlers+1)
+		go c.didCommMsgListener(msgCh)
+
+		for i := 0; i < maxHandlers+1; i++ {
+			msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: DIDDocReqMsgType,
+			})}
+		}
+
+		for i := 0; i < maxHandlers; i++ {
+			select {
+			case <-replying:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "messages are not handled concurrently")
+			}
+		}
+
+		select {
+		case <-replying:
+			require.Fail(t, "more than MaxConcurrentHandlers messages handled at once")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(release)
+
+		select {
+		case <-replying:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "queued message was not handled")
+		}
+
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("unsupported message type", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &DIDDocResp{}
+				err = msg.Decode(pMsg)
+				require.NoError(t, err)
+
+				require.Contains(t, pMsg.Data.ErrorMsg, "unsupported message service type : unsupported-message-type")
+				require.Empty(t, pMsg.Data.DIDDoc)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(struct {
+			Type string `json:"@type,omitempty"`
+		}{Type: "unsupported-message-type"})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("problem report", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.UseProblemReports = true
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgID := uuid.New().String()
+		done := make(chan struct{})
+
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ProblemReport{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+
+				require.Equal(t, ProblemReportMsgType, pMsg.Type)
+				require.NotEmpty(t, pMsg.ID)
+				require.Equal(t, msgID, pMsg.Thread.ID)
+				require.Equal(t, ErrCodeUnsupportedMsgType, pMsg.Description.Code)
+				require.Contains(t, pMsg.Description.Message, "unsupported message service type")
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(struct {
+			ID   string `json:"@id,omitempty"`
+			Type string `json:"@type,omitempty"`
+		}{ID: msgID, Type: "unsupported-message-type"})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("message type versions", func(t *testing.T) {
+		t.Parallel()
+
+		for name, tc := range map[string]struct {
+			msgType  string
+			respType string
+			errMsg   string
+		}{
+			"exact match": {
+				msgType:  DIDDocReqMsgType,
+				respType: DIDDocRespMsgType,
+			},
+			"compatible minor version": {
+				msgType:  "https://trustbloc.dev/blinded-routing/1.1/diddoc-req",
+				respType: DIDDocRespMsgType,
+			},
+			"incompatible major version": {
+				msgType:  "https://trustbloc.dev/blinded-routing/2.0/diddoc-req",
+				respType: "https://trustbloc.dev/blinded-routing/2.0/diddoc-req",
+				errMsg: "unsupported message service type : https://trustbloc.dev/blinded-routing/2.0/diddoc-req " +
+					"(supported versions: 1.0)",
+			},
+		} {
+			config := config()
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.errMsg == "", config.MsgRegistrar.Services()[0].Accept(tc.msgType, nil), name)
+
+			replies := make(chan *ErrorResp, 1)
+
+			c.messenger = message.NewMessenger(&messenger.MockMessenger{
+				ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+					pMsg := &ErrorResp{}
+					require.NoError(t, msg.Decode(pMsg))
+
+					replies <- pMsg
+
+					return nil
+				},
+			})
+
+			c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: tc.msgType,
+			})})
+
+			pMsg := <-replies
+			require.Equal(t, tc.respType, pMsg.Type, name)
+
+			if tc.errMsg == "" {
+				require.Empty(t, pMsg.Data.Code, name)
+			} else {
+				require.Equal(t, ErrCodeUnsupportedMsgType, pMsg.Data.Code, name)
+				require.Equal(t, tc.errMsg, pMsg.Data.ErrorMsg, name)
+			}
+		}
+	})
+
+	t.Run("messenger reply error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				return errors.New("reply error")
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(struct {
+			Type string `json:"@type,omitempty"`
+		}{Type: "unsupported-message-type"})}
+	})
+
+	t.Run("messenger reply retried", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		config := config()
+		config.ReplyRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+		config.DeadLetter = func(service.DIDCommMsg, service.DIDCommMsgMap, error) {
+			require.Fail(t, "reply was dead lettered")
+		}
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				calls++
+				if calls < 3 {
+					return errors.New("reply error")
+				}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("messenger reply dead lettered", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		replyErr := errors.New("reply error")
+
+		var (
+			deadMsg   service.DIDCommMsg
+			deadReply service.DIDCommMsgMap
+			deadErr   error
+		)
+
+		config := config()
+		config.ReplyRetry = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+		config.DeadLetter = func(msg service.DIDCommMsg, reply service.DIDCommMsgMap, err error) {
+			deadMsg, deadReply, deadErr = msg, reply, err
+		}
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				calls++
+
+				return replyErr
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		})})
+
+		require.Equal(t, 2, calls)
+		require.ErrorIs(t, deadErr, replyErr)
+		require.Equal(t, reqID, deadMsg.ID())
+		require.Equal(t, DIDDocRespMsgType, deadReply.Type())
+	})
+
+	t.Run("messenger reply timeout", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		calls := make(chan struct{}, 2)
+		deadErr := make(chan error, 1)
+
+		config := config()
+		config.ReplyTimeout = 10 * time.Millisecond
+		config.ReplyRetry = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+		config.DeadLetter = func(_ service.DIDCommMsg, _ service.DIDCommMsgMap, err error) {
+			deadErr <- err
+		}
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				calls <- struct{}{}
+
+				<-release
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		require.ErrorIs(t, <-deadErr, context.DeadlineExceeded)
+		require.Len(t, calls, 2)
+	})
+
+	t.Run("did doc request", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &DIDDocResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+
+				didDoc, dErr := did.ParseDocument(pMsg.Data.DIDDoc)
+				require.NoError(t, dErr)
+
+				require.Contains(t, didDoc.ID, "did:")
+				require.Equal(t, pMsg.Type, DIDDocRespMsgType)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("register route request", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Empty(t, pMsg.Data)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+}
+
+func TestIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	var ids []string
+
+	config := config()
+	config.IDGenerator = func() string {
+		return fmt.Sprintf("id-%d", len(ids)+1)
+	}
+	config.AriesMessenger = &messenger.MockMessenger{
+		ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+			ids = append(ids, msg.ID())
+
+			return nil
+		},
+	}
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+	txnID := uuid.New().String()
+
+	err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+	require.NoError(t, err)
+
+	didDocBytes, err := didDoc.JSONBytes()
+	require.NoError(t, err)
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+		ID:   uuid.New().String(),
+		Type: DIDDocReqMsgType,
+	})})
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+		ID:     uuid.New().String(),
+		Type:   RegisterRouteReqMsgType,
+		Thread: &decorator.Thread{PID: txnID},
+		Data:   &ConnReqData{DIDDoc: didDocBytes},
+	})})
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+		ID:   uuid.New().String(),
+		Type: RegisterRouteReqMsgType,
+	})})
+
+	c.problemReports = true
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+		ID:   uuid.New().String(),
+		Type: RegisterRouteReqMsgType,
+	})})
+
+	require.Equal(t, []string{"id-1", "id-2", "id-3", "id-4"}, ids)
+}
+
+func TestHandleRequests(t *testing.T) {
+	t.Parallel()
+
+	t.Run("did doc request", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		msgMap, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+		require.Equal(t, DIDDocRespMsgType, msgMap.Type())
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		didDoc, err := did.ParseDocument(pMsg.Data.DIDDoc)
+		require.NoError(t, err)
+		require.Equal(t, didDoc.ID, pMsg.Data.DID)
+
+		txnBytes, err := c.store.Get(txnKey(reqID))
+		require.NoError(t, err)
+		require.Equal(t, didDoc.ID, parseTxnData(txnBytes).DID)
+	})
+
+	t.Run("register route request", func(t *testing.T) {
+		t.Parallel()
+
+		routerConnID := uuid.New().String()
+
+		config := config()
+		config.ServiceEndpoints = []string{"http://adapter.com", "ws://adapter.com"}
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return routerConnID, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		thid := uuid.New().String()
+
+		msgMap, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{ID: thid, PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.NoError(t, err)
+		require.Equal(t, RegisterRouteRespMsgType, msgMap.Type())
+
+		pMsg := &ConnResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+		require.Equal(t, &decorator.Thread{ID: thid, PID: txnID}, pMsg.Thread)
+		require.Equal(t, &ConnRespData{
+			ConnectionID:     routerConnID,
+			RoutingEndpoints: []string{"http://adapter.com", "ws://adapter.com"},
+		}, pMsg.Data)
+	})
+
+	t.Run("register route request after did doc request", func(t *testing.T) {
+		t.Parallel()
+
+		var myDID string
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(d string, _ *did.Doc, _ ...didexchange.ConnectionOption) (string, error) {
+				myDID = d
+
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		msgMap, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		routerDIDDoc, err := did.ParseDocument(pMsg.Data.DIDDoc)
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.NoError(t, err)
+		require.Equal(t, routerDIDDoc.ID, myDID)
+	})
+
+	t.Run("register route request with a parent thread id other than the diddoc-req id", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		// the txn is namespaced, the diddoc-req id alone isn't a key
+		_, err = c.store.Get(txnID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		pthid := uuid.New().String()
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{ID: txnID, PID: pthid},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+		require.Contains(t, err.Error(),
+			"no diddoc-req found for parent thread id "+pthid+", it must be the id of the diddoc-req")
+
+		_, err = c.store.Get(txnKey(txnID))
+		require.NoError(t, err)
+	})
+
+	t.Run("register route request with a parent thread id naming another store entry", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		// the connection mapping of another client
+		connID := uuid.New().String()
+		require.NoError(t, c.store.Put(connID, []byte(uuid.New().String())))
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: connID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+
+		_, err = c.store.Get(connID)
+		require.NoError(t, err)
+	})
+
+	t.Run("register route request connection label", func(t *testing.T) {
+		t.Parallel()
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		for name, tc := range map[string]struct {
+			reqLabel string
+			label    func(*did.Doc) string
+			expected string
+		}{
+			"none":            {},
+			"from request":    {reqLabel: "wallet", label: func(*did.Doc) string { return "configured" }, expected: "wallet"},
+			"from config":     {label: func(d *did.Doc) string { return "client " + d.ID }, expected: "client " + didDoc.ID},
+			"config is blank": {label: func(*did.Doc) string { return "" }},
+		} {
+			var label string
+
+			config := config()
+			config.ConnectionLabel = tc.label
+			config.DIDExchangeClient = &mockdidex.MockClient{
+				CreateConnectionFunc: func(_ string, _ *did.Doc, opts ...didexchange.ConnectionOption) (string, error) {
+					conn := &didexchange.Connection{Record: &connection.Record{}}
+					for _, opt := range opts {
+						opt(conn)
+					}
+
+					label = conn.TheirLabel
+
+					return uuid.New().String(), nil
+				},
+			}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			txnID := uuid.New().String()
+
+			err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+			require.NoError(t, err)
+
+			_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: didDocBytes, Label: tc.reqLabel},
+			})})
+			require.NoError(t, err, name)
+			require.Equal(t, tc.expected, label, name)
+		}
+	})
+
+	t.Run("register route request connection options", func(t *testing.T) {
+		t.Parallel()
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		for name, tc := range map[string]struct {
+			reqLabel string
+			expected string
+		}{
+			"configured label":         {expected: "configured"},
+			"request label precedence": {reqLabel: "wallet", expected: "wallet"},
+		} {
+			conn := &didexchange.Connection{Record: &connection.Record{}}
+
+			config := config()
+			config.ConnectionOptions = []didexchange.ConnectionOption{
+				didexchange.WithImplicit(true),
+				didexchange.WithInvitationID("invitation-1"),
+				didexchange.WithTheirLabel("configured"),
+			}
+			config.DIDExchangeClient = &mockdidex.MockClient{
+				CreateConnectionFunc: func(_ string, _ *did.Doc, opts ...didexchange.ConnectionOption) (string, error) {
+					for _, opt := range opts {
+						opt(conn)
+					}
+
+					return uuid.New().String(), nil
+				},
+			}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			txnID := uuid.New().String()
+
+			err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+			require.NoError(t, err)
+
+			_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: didDocBytes, Label: tc.reqLabel},
+			})})
+			require.NoError(t, err, name)
+			require.True(t, conn.Implicit, name)
+			require.Equal(t, "invitation-1", conn.InvitationID, name)
+			require.Equal(t, tc.expected, conn.TheirLabel, name)
+			require.Len(t, c.connOpts, 3, name)
+		}
+	})
+
+	t.Run("register route request dry run", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				require.Fail(t, "connection created in a dry run")
+
+				return "", nil
+			},
+		}
+		config.MediatorClient = &mockmediator.MockClient{RegisterErr: errors.New("route registered in a dry run")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		req := message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes, DryRun: true},
+		})}
+
+		msgMap, err := c.HandleConnReq(context.Background(), req)
+		require.NoError(t, err)
+
+		pMsg := &ConnResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+		require.Equal(t, txnID, pMsg.Thread.PID)
+		require.Equal(t, &ConnRespData{RoutingEndpoints: []string{"http://adapter.com"}, DryRun: true}, pMsg.Data)
+
+		// the txn is kept for the actual registration
+		_, err = c.store.Get(txnKey(txnID))
+		require.NoError(t, err)
+
+		// a dry run for an unknown txn fails like the actual registration
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: uuid.New().String()},
+			Data:   &ConnReqData{DIDDoc: didDocBytes, DryRun: true},
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+	})
+
+	t.Run("register route request endpoint preferences", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ServiceEndpoints = []string{"http://adapter.com", "ws://adapter.com", "https://adapter.com"}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		for name, tc := range map[string]struct {
+			data     ConnReqData
+			expected []string
+		}{
+			"no preference": {
+				expected: []string{"http://adapter.com", "ws://adapter.com", "https://adapter.com"},
+			},
+			"endpoint hint": {
+				data:     ConnReqData{EndpointHint: "https://adapter.com"},
+				expected: []string{"https://adapter.com", "http://adapter.com", "ws://adapter.com"},
+			},
+			"unknown endpoint hint": {
+				data:     ConnReqData{EndpointHint: "https://evil.com"},
+				expected: []string{"http://adapter.com", "ws://adapter.com", "https://adapter.com"},
+			},
+			"protocols": {
+				data:     ConnReqData{Protocols: []string{"WS", "https"}},
+				expected: []string{"ws://adapter.com", "https://adapter.com", "http://adapter.com"},
+			},
+			"endpoint hint and protocols": {
+				data:     ConnReqData{EndpointHint: "http://adapter.com", Protocols: []string{"https"}, TTL: 3600},
+				expected: []string{"http://adapter.com", "https://adapter.com", "ws://adapter.com"},
+			},
+		} {
+			txnID := uuid.New().String()
+
+			require.NoError(t, c.store.Put(txnKey(txnID), []byte(uuid.New().String())), name)
+
+			data := tc.data
+			data.DIDDoc = didDocBytes
+			data.DryRun = true
+
+			msgMap, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &data,
+			})})
+			require.NoError(t, err, name)
+
+			pMsg := &ConnResp{}
+			require.NoError(t, msgMap.Decode(pMsg), name)
+			require.Equal(t, tc.expected, pMsg.Data.RoutingEndpoints, name)
+		}
+
+		// the configured order is not changed
+		require.Equal(t, []string{"http://adapter.com", "ws://adapter.com", "https://adapter.com"}, c.endpoints)
+	})
+
+	t.Run("replayed register route request", func(t *testing.T) {
+		t.Parallel()
+
+		var connections int
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				connections++
+
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		req := message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})}
+
+		routerConnID := func(msgMap service.DIDCommMsgMap) string {
+			resp := &ConnResp{}
+			require.NoError(t, msgMap.Decode(resp))
+
+			return resp.Data.ConnectionID
+		}
+
+		msgMap, err := c.HandleConnReq(context.Background(), req)
+		require.NoError(t, err)
+
+		registered := routerConnID(msgMap)
+
+		// the replay gets the same reply
+		msgMap, err = c.HandleConnReq(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, registered, routerConnID(msgMap))
+
+		// the did doc is compared in canonical form
+		reordered, err := message.CanonicalJSON(didDocBytes)
+		require.NoError(t, err)
+		require.NotEqual(t, didDocBytes, reordered)
+
+		msgMap, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: reordered},
+		})})
+		require.NoError(t, err)
+		require.Equal(t, registered, routerConnID(msgMap))
+
+		otherDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		otherDoc.ID = "did:peer:other"
+
+		otherDocBytes, err := otherDoc.JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: otherDocBytes},
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeRouteRegistered, errorCode(err))
+		require.Contains(t, err.Error(), "route already registered for parent thread id "+txnID)
+		require.Equal(t, 1, connections)
+
+		// the marker expires with the txns
+		require.NoError(t, c.deleteExpiredTxns(time.Now().Add(time.Hour)))
+
+		_, err = c.HandleConnReq(context.Background(), req)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+	})
+
+	t.Run("concurrent register route requests", func(t *testing.T) {
+		t.Parallel()
+
+		var connections int32
+
+		creating, release := make(chan struct{}), make(chan struct{})
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				atomic.AddInt32(&connections, 1)
+				close(creating)
+				<-release
+
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		req := func() message.Msg {
+			return message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: didDocBytes},
+			})}
+		}
+
+		first := make(chan error, 1)
+
+		go func() {
+			_, errFirst := c.HandleConnReq(context.Background(), req())
+			first <- errFirst
+		}()
+
+		<-creating
+
+		_, err = c.HandleConnReq(context.Background(), req())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeRouteRegistered, errorCode(err))
+		require.Contains(t, err.Error(), "route registration in progress for parent thread id "+txnID)
+
+		close(release)
+		require.NoError(t, <-first)
+
+		_, err = c.HandleConnReq(context.Background(), req())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, atomic.LoadInt32(&connections))
+	})
+
+	t.Run("register route request retried after connection lookup error", func(t *testing.T) {
+		t.Parallel()
+
+		lookup := &mockconn.MockConnectionsLookup{ConnIDByDIDsErr: errors.New("connection store down")}
+
+		config := config()
+		config.ConnectionLookup = lookup
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		req := message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})}
+
+		_, err = c.HandleConnReq(context.Background(), req)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeConnectionLookup, errorCode(err))
+
+		// neither the txn is deleted nor the route marked registered
+		require.False(t, c.isRegistered(context.Background(), txnID))
+
+		connID := uuid.New().String()
+		lookup.ConnIDByDIDsErr = nil
+		lookup.ConnIDByDIDs = connID
+
+		msgMap, err := c.HandleConnReq(context.Background(), req)
+		require.NoError(t, err)
+
+		resp := &ConnResp{}
+		require.NoError(t, msgMap.Decode(resp))
+
+		routerConnID, err := c.store.Get(connID)
+		require.NoError(t, err)
+		require.Equal(t, resp.Data.ConnectionID, string(routerConnID))
+		require.True(t, c.isRegistered(context.Background(), txnID))
+	})
+
+	t.Run("register route request error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		msgMap, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+		})})
+		require.Error(t, err)
+		require.Nil(t, msgMap)
+		require.Equal(t, ErrCodeParentThreadIDMissing, errorCode(err))
+	})
+}
+
+func TestCreateRouterDID(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ServiceEndpoints = []string{"http://adapter.com", "ws://adapter.com"}
+		config.RouterServiceTemplate = did.Service{RoutingKeys: []string{"did:key:router"}}
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(_ string, doc *did.Doc, _ ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				doc.ID = "did:peer:router"
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		doc, err := c.CreateRouterDID()
+		require.NoError(t, err)
+		require.Equal(t, "did:peer:router", doc.ID)
+		require.Len(t, doc.Service, 2)
+
+		for i, endpoint := range config.ServiceEndpoints {
+			uri, err := doc.Service[i].ServiceEndpoint.URI()
+			require.NoError(t, err)
+			require.Equal(t, endpoint, uri)
+			require.Equal(t, []string{"did:key:router"}, doc.Service[i].RoutingKeys)
+		}
+
+		txns, err := c.storedTxns()
+		require.NoError(t, err)
+		require.Empty(t, txns)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		_, err = c.CreateRouterDID()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create did error")
+	})
+}
+
+func TestCallbacks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("did doc created", func(t *testing.T) {
+		t.Parallel()
+
+		created := make(chan *did.Doc, 2)
+
+		config := config()
+		config.OnDIDDocCreated = func(doc *did.Doc) {
+			created <- doc
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		req := service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})
+
+		msgMap, err := c.HandleDIDDocReq(context.Background(), req)
+		require.NoError(t, err)
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		didDoc, err := did.ParseDocument(pMsg.Data.DIDDoc)
+		require.NoError(t, err)
+
+		select {
+		case doc := <-created:
+			require.Equal(t, didDoc.ID, doc.ID)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+
+		// a repeated request is served the same did doc, it isn't created again
+		_, err = c.HandleDIDDocReq(context.Background(), req)
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+		require.Empty(t, created)
+	})
+
+	t.Run("route registered", func(t *testing.T) {
+		t.Parallel()
+
+		type registered struct {
+			connID   string
+			theirDID *did.Doc
+		}
+
+		routes := make(chan registered, 1)
+		routerConnID := uuid.New().String()
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return routerConnID, nil
+			},
+		}
+		config.OnRouteRegistered = func(connectionID string, theirDID *did.Doc) {
+			routes <- registered{connID: connectionID, theirDID: theirDID}
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.NoError(t, err)
+
+		select {
+		case route := <-routes:
+			require.Equal(t, routerConnID, route.connID)
+			require.Equal(t, didDoc.ID, route.theirDID.ID)
+
+			// the callback gets its own doc, not the cached one
+			route.theirDID.ID = "did:example:modified"
+
+			cached, err := c.didDocs.parse(didDocBytes)
+			require.NoError(t, err)
+			require.Equal(t, didDoc.ID, cached.ID)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("route registration error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MediatorClient = &mockmediator.MockClient{RegisterErr: errors.New("register error")}
+		config.OnRouteRegistered = func(string, *did.Doc) {
+			require.Fail(t, "callback called for a failed registration")
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.Error(t, err)
+		require.NoError(t, c.Close(context.Background()))
+	})
+}
+
+// panickingMetrics is a Metrics panicking on every call.
+type panickingMetrics struct{}
+
+func (panickingMetrics) IncMessageReceived(string) { panic("metrics failure") }
+
+func (panickingMetrics) IncMessageError(string) { panic("metrics failure") }
+
+func (panickingMetrics) ObserveHandlerDuration(string, time.Duration) { panic("metrics failure") }
+
+func TestHookPanics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("callbacks, metrics, redactor and sanitizer", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan service.DIDCommMsgMap, 2)
+
+		config := config()
+		config.Metrics = panickingMetrics{}
+		config.DebugLogPayloads = true
+		config.RedactPayload = func(service.DIDCommMsgMap) service.DIDCommMsgMap { panic("redactor failure") }
+		config.ErrorSanitizer = func(error) string { panic("sanitizer failure") }
+		config.OnDIDDocCreated = func(*did.Doc) { panic("callback failure") }
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				replies <- msg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		require.Equal(t, DIDDocRespMsgType, (<-replies).Type())
+
+		// the error reply falls back to the error code when the sanitizer panics
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: uuid.New().String()},
+		})})
+
+		errResp := &ErrorResp{}
+		require.NoError(t, (<-replies).Decode(errResp))
+		require.Equal(t, ErrCodeDIDDocMissing, errResp.Data.Code)
+		require.Equal(t, ErrCodeDIDDocMissing, errResp.Data.ErrorMsg)
+
+		require.NoError(t, c.Close(context.Background()))
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		t.Parallel()
+
+		called := make(chan struct{}, 1)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				return errors.New("outbound failure")
+			},
+		}
+		config.DeadLetter = func(service.DIDCommMsg, service.DIDCommMsgMap, error) {
+			called <- struct{}{}
+
+			panic("dead letter failure")
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+
+		<-called
+
+		require.NoError(t, c.Close(context.Background()))
+	})
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	t.Parallel()
+
+	didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		msg  service.DIDCommMsgMap
+		code string
+	}{
+		"did doc request": {
+			msg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: DIDDocReqMsgType,
+			}),
+			code: ErrCodeTxnFetch,
+		},
+		"register route request": {
+			msg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: uuid.New().String()},
+				Data:   &ConnReqData{DIDDoc: didDocBytes},
+			}),
+			code: ErrCodeTxnFetch,
+		},
+	} {
+		config := config()
+		config.HandlerTimeout = 10 * time.Millisecond
+
+		replies := make(chan *ErrorResp, 1)
+
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(_ string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				require.NoError(t, msg.Decode(pMsg))
+
+				replies <- pMsg
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		release := make(chan struct{})
+		c.store = &blockingStore{Store: c.store, release: release}
+
+		go c.handleMsg(message.Msg{DIDCommMsg: tc.msg})
+
+		select {
+		case pMsg := <-replies:
+			require.Equal(t, tc.code, pMsg.Data.Code, name)
+			require.Contains(t, pMsg.Data.ErrorMsg, context.DeadlineExceeded.Error(), name)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "handler did not time out", name)
+		}
+
+		close(release)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	metrics := newMockMetrics()
+
+	config := config()
+	config.Metrics = metrics
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+		ID:   uuid.New().String(),
+		Type: DIDDocReqMsgType,
+	})})
+
+	c.handleMsg(message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+		ID:   uuid.New().String(),
+		Type: RegisterRouteReqMsgType,
+	})})
+
+	require.Equal(t, map[string]int{DIDDocReqMsgType: 1, RegisterRouteReqMsgType: 1}, metrics.received)
+	require.Equal(t, map[string]int{DIDDocReqMsgType: 1, RegisterRouteReqMsgType: 1}, metrics.durations)
+	require.Equal(t, map[string]int{RegisterRouteReqMsgType: 1}, metrics.errors)
+}
+
+func TestDIDDocReq(t *testing.T) {
+	t.Parallel()
+
+	t.Run("create did doc vm error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		expectErr := "expected err"
+
+		config.KeyManager = &mockkms.KeyManager{CrAndExportPubKeyErr: errors.New(expectErr)}
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, DIDDocRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, expectErr)
+				require.Equal(t, ErrCodeDIDCreation, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("create did doc keyagreement vm error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		config.KeyAgrType = "foo"
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, DIDDocRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "failed to create new keyagreement VM")
+				require.Equal(t, ErrCodeDIDCreation, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("create did doc error classification", func(t *testing.T) {
+		t.Parallel()
+
+		for name, tc := range map[string]struct {
+			err  error
+			code string
+		}{
+			"unsupported method": {err: errors.New("did method x not supported for vdr"), code: ErrCodeDIDCreation},
+			"not found":          {err: fmt.Errorf("resolve : %w", vdr.ErrNotFound), code: ErrCodeDIDCreation},
+			"network": {
+				err:  &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+				code: ErrCodeDIDCreationUnavailable,
+			},
+			"timeout": {
+				err:  fmt.Errorf("create : %w", context.DeadlineExceeded),
+				code: ErrCodeDIDCreationUnavailable,
+			},
+			"temporary": {err: temporaryErr{}, code: ErrCodeDIDCreationUnavailable},
+		} {
+			config := config()
+			config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: tc.err}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			msgMap, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: DIDDocReqMsgType,
+			}))
+			require.Error(t, err, name)
+			require.Nil(t, msgMap, name)
+			require.Equal(t, tc.code, errorCode(err), name)
+		}
+	})
+
+	t.Run("create did doc error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		done := make(chan struct{})
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, DIDDocRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "create did error")
+				require.Equal(t, ErrCodeDIDCreation, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("multiple service endpoints", func(t *testing.T) {
+		t.Parallel()
+
+		endpoints := []string{"https://adapter.com", "wss://adapter.com/ws"}
+
+		config := config()
+		config.ServiceEndpoints = endpoints
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(_ string, doc *did.Doc, _ ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				doc.ID = "did:peer:123456789abcdefghi"
+
+				for i := range doc.Service {
+					doc.Service[i].ID = uuid.New().String()
+				}
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.Equal(t, endpoints[0], c.endpoint)
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		doc := struct {
+			Service []struct {
+				Type            string `json:"type"`
+				ServiceEndpoint string `json:"serviceEndpoint"`
+			} `json:"service"`
+		}{}
+		require.NoError(t, json.Unmarshal(pMsg.Data.DIDDoc, &doc))
+		require.Len(t, doc.Service, len(endpoints))
+
+		for i, endpoint := range endpoints {
+			require.Equal(t, endpoint, doc.Service[i].ServiceEndpoint)
+			require.Equal(t, didCommServiceType, doc.Service[i].Type)
+		}
+	})
+
+	t.Run("router service template", func(t *testing.T) {
+		t.Parallel()
+
+		endpoints := []string{"https://adapter.com", "wss://adapter.com/ws"}
+
+		config := config()
+		config.ServiceEndpoints = endpoints
+		config.RouterServiceTemplate = did.Service{
+			ID:              "ignored",
+			Type:            "ignored",
+			Priority:        2,
+			RecipientKeys:   []string{"did:key:recipient"},
+			RoutingKeys:     []string{"did:key:router1", "did:key:router2"},
+			ServiceEndpoint: model.NewDIDCommV1Endpoint("https://ignored.com"),
+			Accept:          []string{"didcomm/aip2;env=rfc19"},
+		}
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(_ string, doc *did.Doc, _ ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				doc.ID = "did:peer:123456789abcdefghi"
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		doc := struct {
+			Service []struct {
+				ID              string   `json:"id"`
+				Type            string   `json:"type"`
+				Priority        uint     `json:"priority"`
+				RecipientKeys   []string `json:"recipientKeys"`
+				RoutingKeys     []string `json:"routingKeys"`
+				ServiceEndpoint string   `json:"serviceEndpoint"`
+				Accept          []string `json:"accept"`
+			} `json:"service"`
+		}{}
+		require.NoError(t, json.Unmarshal(pMsg.Data.DIDDoc, &doc))
+		require.Len(t, doc.Service, len(endpoints))
+
+		for i, endpoint := range endpoints {
+			svc := doc.Service[i]
+
+			require.NotEqual(t, "ignored", svc.ID)
+			require.Equal(t, didCommServiceType, svc.Type)
+			require.Equal(t, endpoint, svc.ServiceEndpoint)
+			require.Equal(t, uint(2), svc.Priority)
+			require.Equal(t, []string{"did:key:recipient"}, svc.RecipientKeys)
+			require.Equal(t, []string{"did:key:router1", "did:key:router2"}, svc.RoutingKeys)
+			require.Equal(t, []string{"didcomm/aip2;env=rfc19"}, svc.Accept)
+		}
+	})
+
+	t.Run("router did method", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			configured string
+			expected   string
+		}{
+			{configured: "", expected: "peer"},
+			{configured: "key", expected: "key"},
+		} {
+			config := config()
+			config.RouterDIDMethod = tc.configured
+
+			var method string
+
+			config.VDRIRegistry = &mockvdr.MockVDRegistry{
+				CreateFunc: func(m string, doc *did.Doc, _ ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+					method = m
+
+					return &did.DocResolution{DIDDocument: mockdiddoc.GetMockDIDDoc(t, false)}, nil
+				},
+			}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   uuid.New().String(),
+				Type: DIDDocReqMsgType,
+			})})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, method)
+		}
+	})
+
+	t.Run("did:web router did", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.RouterDIDMethod = WebDIDMethod
+		config.ServiceEndpoints = []string{"https://adapter.com:8443/router", "https://adapter.com/router"}
+
+		var (
+			created []*did.Doc
+			paths   []interface{}
+		)
+
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateErr: errors.New("registry must not create did:web"),
+		}
+		config.WebVDR = &mockvdr.MockVDR{
+			CreateFunc: func(doc *did.Doc, o ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				opts := &vdr.DIDMethodOpts{Values: map[string]interface{}{}}
+				for _, opt := range o {
+					opt(opts)
+				}
+
+				require.Equal(t, "adapter.com:8443", opts.Values[WebDIDDomainOpt])
+
+				created = append(created, doc)
+				paths = append(paths, opts.Values[WebDIDPathOpt])
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnIDs := []string{uuid.New().String(), uuid.New().String()}
+
+		for _, txnID := range txnIDs {
+			_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   txnID,
+				Type: DIDDocReqMsgType,
+			})})
+			require.NoError(t, err)
+		}
+
+		require.Len(t, created, 2)
+
+		for i, txnID := range txnIDs {
+			require.Equal(t, "did:web:adapter.com%3A8443:router:"+txnID, created[i].ID)
+			require.Len(t, created[i].Service, 2)
+			require.Equal(t, created[i].ID+"#did-communication-1", created[i].Service[0].ID)
+			require.Equal(t, created[i].ID+"#did-communication-2", created[i].Service[1].ID)
+			require.Equal(t, "router/"+txnID, paths[i])
+		}
+	})
+
+	t.Run("did:web router did without a web vdr", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.RouterDIDMethod = WebDIDMethod
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "requires a WebVDR")
+	})
+
+	t.Run("did:web router did from invalid endpoint", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.RouterDIDMethod = WebDIDMethod
+		config.WebVDR = &mockvdr.MockVDR{}
+		config.ServiceEndpoint = "adapter"
+		config.AnyServiceEndpoint = true
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "router did:web from endpoint")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, DIDDocRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "save router did")
+				require.Equal(t, ErrCodeTxnSave, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrPut: errors.New("save error")}
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("duplicate requests create a single did", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu      sync.Mutex
+			creates int
+		)
+
+		config := config()
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(string, *did.Doc, ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				mu.Lock()
+				creates++
+				mu.Unlock()
+
+				return &did.DocResolution{DIDDocument: mockdiddoc.GetMockDIDDoc(t, false)}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		const requests = 5
+
+		docs := make(chan json.RawMessage, requests)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < requests; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				msgMap, errHandle := c.handleDIDDocReq(context.Background(), message.Msg{
+					DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{ID: reqID, Type: DIDDocReqMsgType}),
+				})
+				require.NoError(t, errHandle)
+
+				pMsg := &DIDDocResp{}
+				require.NoError(t, msgMap.Decode(pMsg))
+
+				docs <- pMsg.Data.DIDDoc
+			}()
+		}
+
+		wg.Wait()
+		close(docs)
+
+		first := <-docs
+		for doc := range docs {
+			require.JSONEq(t, string(first), string(doc))
+		}
+
+		// a retry after the first requests completed gets the same did doc
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		pMsg := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+		require.JSONEq(t, string(first), string(pMsg.Data.DIDDoc))
+
+		require.Equal(t, 1, creates)
+	})
+
+	t.Run("txn without did doc is replaced", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		err = c.store.Put(txnKey(reqID), []byte("did:example:legacy"))
+		require.NoError(t, err)
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		txnBytes, err := c.store.Get(txnKey(reqID))
+		require.NoError(t, err)
+
+		txn := parseTxnData(txnBytes)
+		require.NotEqual(t, "did:example:legacy", txn.DID)
+		require.NotNil(t, txn.DIDDoc)
+	})
+
+	t.Run("txn fetch error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnFetch, errorCode(err))
+		require.Contains(t, err.Error(), "fetch txn data : get error")
+	})
+
+	t.Run("audit record", func(t *testing.T) {
+		t.Parallel()
+
+		audit := &recordingAuditStore{}
+		clock := &fakeClock{now: time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)}
+
+		config := config()
+		config.AuditStore = audit
+		config.Clock = clock
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+		msg := message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{ID: reqID, Type: DIDDocReqMsgType}),
+			TheirDID:   "did:peer:client",
+		}
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), msg)
+		require.NoError(t, err)
+
+		resp := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(resp))
+
+		require.Equal(t, []*DIDCreatedRecord{{
+			MsgID:     reqID,
+			DID:       resp.Data.DID,
+			TheirDID:  "did:peer:client",
+			Endpoint:  "http://adapter.com",
+			CreatedAt: clock.Now(),
+		}}, audit.recorded())
+
+		// the did of a repeated request is not created again
+		_, err = c.handleDIDDocReq(context.Background(), msg)
+		require.NoError(t, err)
+		require.Len(t, audit.recorded(), 1)
+	})
+
+	t.Run("no audit record on failure", func(t *testing.T) {
+		t.Parallel()
+
+		audit := &recordingAuditStore{}
+
+		config := config()
+		config.AuditStore = audit
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{ID: uuid.New().String(), Type: DIDDocReqMsgType}),
+		})
+		require.Error(t, err)
+
+		require.Empty(t, audit.recorded())
+	})
+
+	t.Run("audit record before the txn is saved", func(t *testing.T) {
+		t.Parallel()
+
+		audit := &recordingAuditStore{}
+
+		config := config()
+		config.AuditStore = audit
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &failingPutStore{Store: c.store, prefix: routerDIDKeyPrefix, err: errors.New("put error")}
+
+		reqID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{ID: reqID, Type: DIDDocReqMsgType}),
+		})
+		require.EqualError(t, err, "save router did : put error")
+		require.Equal(t, ErrCodeTxnSave, errorCode(err))
+		require.Len(t, audit.recorded(), 1)
+
+		// the did is not handed out, nor found by a retried diddoc-req
+		_, err = c.store.Get(txnKey(reqID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Zero(t, c.pendingTxns.len())
+	})
+
+	t.Run("audit record error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.AuditStore = &recordingAuditStore{err: errors.New("audit db down")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{ID: reqID, Type: DIDDocReqMsgType}),
+		})
+		require.EqualError(t, err, "record did created : audit db down")
+		require.Equal(t, ErrCodeAuditRecord, errorCode(err))
+
+		// neither the txn nor the router did marker is saved
+		txns, err := c.storedTxns()
+		require.NoError(t, err)
+		require.Empty(t, txns)
+	})
+
+	t.Run("mediator routing keys", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MediatorClient = &mockMediatorConfig{
+			routingKeys: []string{"did:key:z6MkRouting#z6MkRouting"},
+			endpoint:    "https://mediator.example.com",
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		resp := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(resp))
+		require.Equal(t, []string{"did:key:z6MkRouting#z6MkRouting"}, resp.Data.RoutingKeys)
+		require.Equal(t, "https://mediator.example.com", resp.Data.RoutingEndpoint)
+		require.NotEmpty(t, resp.Data.DIDDoc)
+	})
+
+	t.Run("mediator without config", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		msgMap, err := c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		resp := &DIDDocResp{}
+		require.NoError(t, msgMap.Decode(resp))
+		require.Empty(t, resp.Data.RoutingKeys)
+		require.Empty(t, resp.Data.RoutingEndpoint)
+	})
+
+	t.Run("mediator config error", func(t *testing.T) {
+		t.Parallel()
+
+		var created int32
+
+		audit := &recordingAuditStore{}
+
+		config := config()
+		config.MediatorClient = &mockMediatorConfig{err: errors.New("mediator offline")}
+		config.AuditStore = audit
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(_ string, doc *did.Doc, _ ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				atomic.AddInt32(&created, 1)
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.EqualError(t, err, "get mediator config : mediator offline")
+		require.Equal(t, ErrCodeMediatorConfig, errorCode(err))
+
+		// nothing is left behind: no router did, txn nor audit record
+		require.Zero(t, atomic.LoadInt32(&created))
+		require.Empty(t, audit.recorded())
+
+		_, err = c.store.Get(txnKey(reqID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+}
+
+func TestStoreRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("retry then success", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.StoreRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		store := &flakyStore{Store: c.store, failures: 2}
+		c.store = store
+
+		reqID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   reqID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		_, err = c.store.Get(txnKey(reqID))
+		require.NoError(t, err)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.StoreRetry = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		store := &flakyStore{Store: c.store, failures: 10}
+		c.store = store
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   uuid.New().String(),
+			Type: DIDDocReqMsgType,
+		}))
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnFetch, errorCode(err))
+		require.ErrorIs(t, err, temporaryErr{})
+		require.Equal(t, 2, store.callCount())
+	})
+
+	t.Run("not found is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.StoreRetry = RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		store := &flakyStore{Store: c.store}
+		c.store = store
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		// the txn and registration lookups all miss, each with a single get
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: uuid.New().String()},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Equal(t, 2, store.callCount())
+	})
+
+	t.Run("logical errors are not retried", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.StoreRetry = RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		calls := 0
+		expected := errors.New("put error")
+
+		err = c.withStore(context.Background(), func() error {
+			calls++
+
+			return expected
+		})
+		require.ErrorIs(t, err, expected)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestTxnData(t *testing.T) {
+	t.Parallel()
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		txn := &txnData{DID: "did:example:router", DIDDoc: json.RawMessage(`{"id":"did:example:router"}`)}
+
+		txnBytes, err := json.Marshal(txn)
+		require.NoError(t, err)
+		require.Equal(t, txn, parseTxnData(txnBytes))
+	})
+
+	for name, tc := range map[string]struct {
+		stored string
+		txn    *txnData
+	}{
+		"legacy did":      {stored: "did:example:legacy", txn: &txnData{DID: "did:example:legacy"}},
+		"without did doc": {stored: `{"did":"did:example:router"}`, txn: &txnData{DID: "did:example:router"}},
+	} {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.txn, parseTxnData([]byte(tc.stored)))
+		})
+	}
+}
+
+func TestTxnExpiry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("deletes expired txns only", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.TxnTTL = time.Minute
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   msgID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		connID := uuid.New().String()
+		err = c.store.Put(connID, []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		err = c.deleteExpiredTxns(time.Now())
+		require.NoError(t, err)
+
+		_, err = c.store.Get(txnKey(msgID))
+		require.NoError(t, err)
+
+		err = c.deleteExpiredTxns(time.Now().Add(2 * time.Minute))
+		require.NoError(t, err)
+
+		_, err = c.store.Get(txnKey(msgID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = c.store.Get(connID)
+		require.NoError(t, err)
+	})
+
+	t.Run("txns expire as per the clock", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)}
+
+		config := config()
+		config.TxnTTL = time.Minute
+		config.Clock = clock
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   msgID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		clock.advance(time.Minute)
+		require.NoError(t, c.deleteExpiredTxns(clock.Now()))
+
+		_, err = c.store.Get(txnKey(msgID))
+		require.NoError(t, err)
+
+		clock.advance(time.Second)
+		require.NoError(t, c.deleteExpiredTxns(clock.Now()))
+
+		_, err = c.store.Get(txnKey(msgID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		pMsg := &PingResp{}
+		require.NoError(t, c.pingResp(service.NewDIDCommMsgMap(Ping{ID: "ping-1", Type: PingMsgType})).Decode(pMsg))
+		require.Equal(t, "2022-09-01T12:01:01Z", pMsg.Timestamp)
+	})
+
+	t.Run("sweeper deletes expired txns", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.TxnTTL = 20 * time.Millisecond
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, c.Close(context.Background()))
+		}()
+
+		msgID := uuid.New().String()
+
+		_, err = c.handleDIDDocReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   msgID,
+			Type: DIDDocReqMsgType,
+		})})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			_, err := c.store.Get(txnKey(msgID))
+
+			return errors.Is(err, storage.ErrDataNotFound)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		err = c.deleteExpiredTxns(time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "query txn data")
+	})
+
+	t.Run("iterator error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{QueryReturn: &mockstorage.Iterator{ErrNext: errors.New("next error")}}
+
+		err = c.deleteExpiredTxns(time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "iterate txn data")
+	})
+
+	t.Run("invalid creation time", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		err = c.store.Put(uuid.New().String(), []byte(uuid.New().String()),
+			storage.Tag{Name: txnCreatedTagName, Value: "invalid"})
+		require.NoError(t, err)
+
+		err = c.deleteExpiredTxns(time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "parse creation time")
+	})
+
+	t.Run("delete error", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		err = c.store.Put(uuid.New().String(), []byte(uuid.New().String()),
+			storage.Tag{Name: txnCreatedTagName, Value: "0"})
+		require.NoError(t, err)
+
+		c.store = &failingDeleteStore{Store: c.store, err: errors.New("delete error")}
+
+		err = c.deleteExpiredTxns(time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "delete error")
+	})
+}
+
+func TestTxnStoreRestart(t *testing.T) {
+	t.Parallel()
+
+	// restart runs a diddoc-req, closes the service and sends the register-route-req to a new service with the
+	// given txn store provider.
+	restart := func(t *testing.T, before, after storage.Provider) error {
+		t.Helper()
+
+		config := config()
+		config.TxnStoreProvider = before
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+
+		config.TxnStoreProvider = after
+		config.MsgRegistrar = msghandler.NewRegistrar()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err = New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+
+		return err
+	}
+
+	t.Run("durable store", func(t *testing.T) {
+		t.Parallel()
+
+		provider := mem.NewProvider()
+
+		require.NoError(t, restart(t, provider, provider))
+	})
+
+	t.Run("store reset", func(t *testing.T) {
+		t.Parallel()
+
+		err := restart(t, mem.NewProvider(), mem.NewProvider())
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+		require.Contains(t, err.Error(), "transaction expired, restart flow with a new diddoc-req")
+	})
+
+	t.Run("txn store provider instead of store", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.Store = &mockstorage.Provider{ErrOpenStore: errors.New("open db error")}
+		config.TxnStoreProvider = mem.NewProvider()
+
+		c, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+
+		config.Store = nil
+		config.MsgRegistrar = msghandler.NewRegistrar()
+
+		c, err = New(config)
+		require.NoError(t, err)
+		require.NoError(t, c.Close(context.Background()))
+	})
+}
+
+func TestMaxPendingTxns(t *testing.T) {
+	t.Parallel()
+
+	didDocReq := func(c *Service, id string) error {
+		_, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   id,
+			Type: DIDDocReqMsgType,
+		}))
+
+		return err
+	}
+
+	t.Run("reject new txns", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 2
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		first := uuid.New().String()
+
+		require.NoError(t, didDocReq(c, first))
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+
+		err = didDocReq(c, uuid.New().String())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTooManyTxns, errorCode(err))
+		require.Contains(t, err.Error(), "2 diddoc-req transactions pending, try again later")
+
+		// a repeated request for a pending txn is still served
+		require.NoError(t, didDocReq(c, first))
+	})
+
+	t.Run("evict oldest txns", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 2
+		config.PendingTxnsPolicy = EvictOldestTxns
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		ids := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
+
+		for _, id := range ids {
+			require.NoError(t, didDocReq(c, id))
+			time.Sleep(time.Millisecond)
+		}
+
+		_, err = c.store.Get(txnKey(ids[0]))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		for _, id := range ids[1:] {
+			_, err = c.store.Get(txnKey(id))
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("registration and router did markers are not pending", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 1
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		for _, key := range []string{registeredKey(uuid.New().String()), routerDIDKey("did:peer:" + uuid.New().String())} {
+			err = c.store.Put(key, []byte(uuid.New().String()), storage.Tag{
+				Name:  txnCreatedTagName,
+				Value: strconv.FormatInt(time.Now().UnixNano(), 10),
+			})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+	})
+
+	t.Run("no store scan per request", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 2
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrGet: storage.ErrDataNotFound, ErrQuery: errors.New("query error")}
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+
+		err = didDocReq(c, uuid.New().String())
+		require.Equal(t, ErrCodeTooManyTxns, errorCode(err))
+	})
+
+	t.Run("registered txns are no longer pending", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 1
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return uuid.New().String(), nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		require.NoError(t, didDocReq(c, txnID))
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.NoError(t, err)
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+	})
+
+	t.Run("expired txns are no longer pending", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 1
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+		require.Equal(t, ErrCodeTooManyTxns, errorCode(didDocReq(c, uuid.New().String())))
+
+		require.NoError(t, c.deleteExpiredTxns(time.Now().Add(time.Hour)))
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+	})
+
+	t.Run("pending txns loaded from the store", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 1
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.NoError(t, didDocReq(c, uuid.New().String()))
+		require.NoError(t, c.Close(context.Background()))
+
+		config.MsgRegistrar = msghandler.NewRegistrar()
+
+		c, err = New(config)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+		require.Equal(t, ErrCodeTooManyTxns, errorCode(didDocReq(c, uuid.New().String())))
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MaxPendingTxns = 1
+		config.Store = &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{ErrQuery: errors.New("query error")}}
+
+		_, err := New(config)
+		require.EqualError(t, err, "load pending txns : query txn data : query error")
+	})
+}
+
+func TestRegisterRouteReq(t *testing.T) { // nolint:gocyclo,cyclop
+	t.Parallel()
+
+	t.Run("missing parent thread id", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "parent thread id mandatory")
+				require.Equal(t, ErrCodeParentThreadIDMissing, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("empty did doc in the request", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "data mandatory")
+				require.Equal(t, ErrCodeDIDDocMissing, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: uuid.New().String(),
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("invalid did doc in the request", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		done := make(chan struct{})
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.messenger = message.NewMessenger(&messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "parse did doc")
+				require.Equal(t, ErrCodeDIDDocInvalid, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: uuid.New().String(),
+			},
+			Data: &ConnReqData{
+				DIDDoc: []byte("invalid-did-doc"),
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("unusable did doc in the request", func(t *testing.T) {
+		t.Parallel()
+
+		for name, tc := range map[string]struct {
+			mutate func(doc *did.Doc)
+			errMsg string
+		}{
+			"no service": {
+				mutate: func(doc *did.Doc) { doc.Service = nil },
+				errMsg: "missing did-communication or DIDCommMessaging service",
+			},
+			"no recipient key": {
+				mutate: func(doc *did.Doc) { doc.Service[0].RecipientKeys = nil },
+				errMsg: "missing recipient key",
+			},
+		} {
+			c, err := New(config())
+			require.NoError(t, err)
+
+			didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+			tc.mutate(didDoc)
+
+			didDocBytes, err := didDoc.JSONBytes()
+			require.NoError(t, err)
+
+			_, err = c.handleRouteRegistration(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:   uuid.New().String(),
+				Type: RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{
+					PID: uuid.New().String(),
+				},
+				Data: &ConnReqData{
+					DIDDoc: didDocBytes,
+				},
+			})})
+			require.Error(t, err, name)
+			require.Contains(t, err.Error(), "validate did doc : "+tc.errMsg, name)
+			require.Equal(t, ErrCodeDIDDocInvalid, errorCode(err), name)
+		}
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, true)
+		didDoc.Service[0].ServiceEndpoint = model.NewDIDCommV2Endpoint([]model.DIDCommV2Endpoint{})
+
+		err := validateDIDDoc(didDoc, false)
+		require.EqualError(t, err, "missing service endpoint in DIDCommMessaging service")
+
+		require.NoError(t, validateDIDDoc(mockdiddoc.GetMockDIDDoc(t, true), false))
+	})
+
+	t.Run("transport-less sender", func(t *testing.T) {
+		t.Parallel()
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		didDoc.Service[0].ServiceEndpoint = model.NewDIDCommV1Endpoint(transportQueueEndpoint)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		for value, expected := range map[string]string{
+			"":                                   "service endpoint didcomm:transport/queue is only reachable with return route",
+			decorator.TransportReturnRouteNone:   "service endpoint didcomm:transport/queue is only reachable with return route",
+			decorator.TransportReturnRouteAll:    "",
+			decorator.TransportReturnRouteThread: "",
+		} {
+			c, err := New(config())
+			require.NoError(t, err)
+
+			txnID := uuid.New().String()
+
+			err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+			require.NoError(t, err)
+
+			msg := service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: txnID},
+				Data:   &ConnReqData{DIDDoc: didDocBytes},
+			})
+
+			if value != "" {
+				msg["~transport"] = map[string]interface{}{"~return_route": value}
+			}
+
+			_, err = c.handleRouteRegistration(context.Background(), message.Msg{DIDCommMsg: msg})
+			if expected == "" {
+				require.NoError(t, err, value)
+
+				continue
+			}
+
+			require.Error(t, err, value)
+			require.Contains(t, err.Error(), expected, value)
+			require.Equal(t, ErrCodeDIDDocInvalid, errorCode(err), value)
+		}
+	})
+
+	t.Run("unknown parent thread", func(t *testing.T) {
+		t.Parallel()
+
+		created := false
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				created = true
+
+				return "", nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		pthid := uuid.New().String()
+
+		_, err = c.handleRouteRegistration(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:     uuid.New().String(),
+				Type:   RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{PID: pthid},
+				Data:   &ConnReqData{DIDDoc: didDocBytes},
+			}),
+		})
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+		require.Contains(t, err.Error(), "no diddoc-req found for parent thread id "+pthid)
+		require.False(t, created)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "fetch txn data : get error")
+				require.Equal(t, ErrCodeTxnFetch, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		c.store = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: uuid.New().String(),
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("create connection error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(s string, doc *did.Doc, option ...didexchange.ConnectionOption) (string, error) {
+				return "", errors.New("create conn error")
+			},
+		}
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "create connection")
+				require.Equal(t, ErrCodeConnectionCreation, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("register route error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MediatorClient = &mockmediator.MockClient{
+			RegisterErr: errors.New("register route error"),
+		}
+
+		done := make(chan struct{})
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "route registration")
+				require.Equal(t, ErrCodeRouteRegistration, pMsg.Data.Code)
+
+				done <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "tests are not validated due to timeout")
+		}
+	})
+
+	t.Run("register route retried", func(t *testing.T) {
+		t.Parallel()
+
+		const failures = 2
+
+		for name, tc := range map[string]struct {
+			registerErr error
+			maxAttempts int
+			calls       int
+			errMsg      string
+		}{
+			"success after failures": {
+				registerErr: errors.New("transient"),
+				maxAttempts: failures + 1,
+				calls:       failures + 1,
+			},
+			"attempts exhausted": {
+				registerErr: errors.New("transient"),
+				maxAttempts: failures,
+				calls:       failures,
+				errMsg:      "route registration : transient",
+			},
+			"connection not found": {
+				registerErr: mediatorsvc.ErrConnectionNotFound,
+				maxAttempts: failures + 1,
+				calls:       1,
+				errMsg:      "route registration : connection not found",
+			},
+		} {
+			calls := 0
+
+			config := config()
+			config.RegisterRetry = RetryPolicy{MaxAttempts: tc.maxAttempts, Backoff: time.Millisecond}
+			config.MediatorClient = &mockmediator.MockClient{
+				RegisterFunc: func(string) error {
+					calls++
+
+					if calls <= failures {
+						return tc.registerErr
+					}
+
+					return nil
+				},
+			}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+			txnID := uuid.New().String()
+
+			err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+			require.NoError(t, err)
+
+			didDocBytes, err := didDoc.JSONBytes()
+			require.NoError(t, err)
+
+			_, err = c.handleRouteRegistration(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:   uuid.New().String(),
+				Type: RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{
+					PID: txnID,
+				},
+				Data: &ConnReqData{
+					DIDDoc: didDocBytes,
+				},
+			})})
+
+			if tc.errMsg == "" {
+				require.NoError(t, err, name)
+			} else {
+				require.EqualError(t, err, tc.errMsg, name)
+				require.Equal(t, ErrCodeRouteRegistration, errorCode(err), name)
+			}
+
+			require.Equal(t, tc.calls, calls, name)
+		}
+	})
+
+	t.Run("hung register bounded by the handler context", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		var calls int32
+
+		config := config()
+		config.RegisterRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+		config.MediatorClient = &mockmediator.MockClient{
+			RegisterFunc: func(string) error {
+				atomic.AddInt32(&calls, 1)
+
+				<-release
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = c.handleRouteRegistration(ctx, message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Equal(t, ErrCodeRouteRegistration, errorCode(err))
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("connection id look up error", func(t *testing.T) {
 		t.Parallel()
 
-		c, err := New(config())
-		require.NoError(t, err)
+		config := config()
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDsErr: errors.New("lookup error")}
 
 		done := make(chan struct{})
-
-		c.messenger = &messenger.MockMessenger{
+		config.AriesMessenger = &messenger.MockMessenger{
 			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &DIDDocResp{}
-				err = msg.Decode(pMsg)
-				require.NoError(t, err)
-
-				require.Contains(t, pMsg.Data.ErrorMsg, "unsupported message service type : unsupported-message-type")
-				require.Empty(t, pMsg.Data.DIDDoc)
+				pMsg := &ErrorResp{}
+				dErr := msg.Decode(pMsg)
+				require.NoError(t, dErr)
+				require.Equal(t, pMsg.Type, RegisterRouteRespMsgType)
+				require.Contains(t, pMsg.Data.ErrorMsg, "get connection by dids")
+				require.Equal(t, ErrCodeConnectionLookup, pMsg.Data.Code)
 
 				done <- struct{}{}
 
@@ -84,12 +4198,31 @@ func TestDIDCommMsgListener(t *testing.T) {
 			},
 		}
 
+		c, err := New(config)
+		require.NoError(t, err)
+
 		msgCh := make(chan message.Msg, 1)
 		go c.didCommMsgListener(msgCh)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(struct {
-			Type string `json:"@type,omitempty"`
-		}{Type: "unsupported-message-type"})}
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})}
 
 		select {
 		case <-done:
@@ -98,184 +4231,522 @@ func TestDIDCommMsgListener(t *testing.T) {
 		}
 	})
 
-	t.Run("messenger reply error", func(t *testing.T) {
+	t.Run("txn data deleted after registration", func(t *testing.T) {
 		t.Parallel()
 
 		c, err := New(config())
 		require.NoError(t, err)
 
-		c.messenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				return errors.New("reply error")
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.handleRouteRegistration(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:   uuid.New().String(),
+			Type: RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{
+				PID: txnID,
+			},
+			Data: &ConnReqData{
+				DIDDoc: didDocBytes,
+			},
+		})})
+		require.NoError(t, err)
+
+		_, err = c.store.Get(txnKey(txnID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("txn data delete error does not fail registration", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
+		require.NoError(t, err)
+
+		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		c.store = &failingDeleteStore{Store: c.store, err: errors.New("delete error")}
+
+		didDocBytes, err := didDoc.JSONBytes()
+		require.NoError(t, err)
+
+		msgMap, err := c.handleRouteRegistration(context.Background(), message.Msg{
+			DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+				ID:   uuid.New().String(),
+				Type: RegisterRouteReqMsgType,
+				Thread: &decorator.Thread{
+					PID: txnID,
+				},
+				Data: &ConnReqData{
+					DIDDoc: didDocBytes,
+				},
+			}),
+		})
+		require.NoError(t, err)
+		require.Equal(t, RegisterRouteRespMsgType, msgMap.Type())
+	})
+}
+
+func TestReuseExistingConnections(t *testing.T) {
+	t.Parallel()
+
+	didDoc := mockdiddoc.GetMockDIDDoc(t, false)
+
+	didDocBytes, err := didDoc.JSONBytes()
+	require.NoError(t, err)
+
+	registerRoute := func(c *Service) (*ConnResp, error) {
+		txnID := uuid.New().String()
+
+		err := c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		msgMap, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		if err != nil {
+			return nil, err
+		}
+
+		pMsg := &ConnResp{}
+		require.NoError(t, msgMap.Decode(pMsg))
+
+		return pMsg, nil
+	}
+
+	t.Run("reuse the connection", func(t *testing.T) {
+		t.Parallel()
+
+		existingConnID := uuid.New().String()
+
+		var registered string
+
+		config := config()
+		config.ReuseExistingConnections = true
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				require.Fail(t, "connection created")
+
+				return "", nil
+			},
+			QueryConnectionsFunc: func(p *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				require.Equal(t, didDoc.ID, p.TheirDID)
+
+				return []*didexchange.Connection{
+					{Record: &connection.Record{ConnectionID: existingConnID, TheirDID: didDoc.ID}},
+				}, nil
 			},
 		}
+		config.MediatorClient = &mockmediator.MockClient{RegisterFunc: func(connectionID string) error {
+			registered = connectionID
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+			return nil
+		}}
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(struct {
-			Type string `json:"@type,omitempty"`
-		}{Type: "unsupported-message-type"})}
+		c, err := New(config)
+		require.NoError(t, err)
+
+		resp, err := registerRoute(c)
+		require.NoError(t, err)
+		require.Equal(t, existingConnID, resp.Data.ConnectionID)
+		require.Equal(t, existingConnID, registered)
 	})
 
-	t.Run("did doc request", func(t *testing.T) {
+	t.Run("create a connection if there is none", func(t *testing.T) {
 		t.Parallel()
 
-		c, err := New(config())
+		newConnID := uuid.New().String()
+
+		config := config()
+		config.ReuseExistingConnections = true
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return newConnID, nil
+			},
+			QueryConnectionsFunc: func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				return []*didexchange.Connection{
+					{Record: &connection.Record{ConnectionID: uuid.New().String(), TheirDID: "did:example:other"}},
+				}, nil
+			},
+		}
+
+		c, err := New(config)
 		require.NoError(t, err)
 
-		done := make(chan struct{})
+		resp, err := registerRoute(c)
+		require.NoError(t, err)
+		require.Equal(t, newConnID, resp.Data.ConnectionID)
+	})
 
-		c.messenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &DIDDocResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
+	t.Run("not reused by default", func(t *testing.T) {
+		t.Parallel()
+
+		newConnID := uuid.New().String()
+
+		config := config()
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return newConnID, nil
+			},
+			QueryConnectionsFunc: func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				require.Fail(t, "connections queried")
+
+				return nil, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		resp, err := registerRoute(c)
+		require.NoError(t, err)
+		require.Equal(t, newConnID, resp.Data.ConnectionID)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ReuseExistingConnections = true
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			QueryConnectionsFunc: func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				return nil, errors.New("query error")
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		_, err = registerRoute(c)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeConnectionCreation, errorCode(err))
+		require.Contains(t, err.Error(), "query connections : query error")
+	})
+
+	t.Run("client can't query connections", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ReuseExistingConnections = true
+		config.DIDExchangeClient = struct{ DIDExchange }{&mockdidex.MockClient{}}
+
+		_, err := New(config)
+		require.EqualError(t, err, "reuse existing connections : did exchange client can't query connections")
+	})
+}
+
+func TestUnregisterRouteReq(t *testing.T) {
+	t.Parallel()
+
+	unregisterReq := func() message.Msg {
+		return message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(UnregisterRouteReq{
+			ID:   uuid.New().String(),
+			Type: UnregisterRouteReqMsgType,
+		})}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		connID := uuid.New().String()
+		routerConnID := uuid.New().String()
+		unregistered := make(chan string, 1)
+
+		config := config()
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDs: connID}
+		config.MediatorClient = &mockmediator.MockClient{
+			UnregisterFunc: func(connectionID string) error {
+				unregistered <- connectionID
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		err = c.store.Put(connID, []byte(routerConnID))
+		require.NoError(t, err)
+
+		req := unregisterReq()
+
+		resp, err := c.HandleUnregisterRouteReq(context.Background(), req)
+		require.NoError(t, err)
+
+		require.Equal(t, routerConnID, <-unregistered)
+
+		var respMsg UnregisterRouteResp
+
+		require.NoError(t, resp.Decode(&respMsg))
+		require.Equal(t, UnregisterRouteRespMsgType, respMsg.Type)
+		require.Equal(t, req.DIDCommMsg.ID(), respMsg.Thread.ID)
+		require.Equal(t, routerConnID, respMsg.Data.ConnectionID)
+
+		_, err = c.store.Get(connID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), unregisterReq())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeRouteNotFound, errorCode(err))
+	})
+
+	t.Run("route not found", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.MediatorClient = &mockmediator.MockClient{
+			UnregisterFunc: func(string) error {
+				require.Fail(t, "unregister called without a registered route")
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), unregisterReq())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeRouteNotFound, errorCode(err))
+		require.Contains(t, err.Error(), "no route registered for connection")
+	})
+
+	t.Run("connection lookup error", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDsErr: errors.New("lookup error")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), unregisterReq())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeConnectionLookup, errorCode(err))
+	})
+
+	t.Run("unregister error", func(t *testing.T) {
+		t.Parallel()
+
+		connID := uuid.New().String()
+
+		config := config()
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDs: connID}
+		config.MediatorClient = &mockmediator.MockClient{UnregisterErr: errors.New("unregister error")}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		err = c.store.Put(connID, []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), unregisterReq())
+		require.Error(t, err)
+		require.Equal(t, ErrCodeRouteUnregistration, errorCode(err))
+		require.Contains(t, err.Error(), "unregister error")
+
+		_, err = c.store.Get(connID)
+		require.NoError(t, err)
+	})
+}
+
+func TestRouteTTL(t *testing.T) {
+	t.Parallel()
+
+	// register runs a diddoc-req and its register-route-req with the ttl, and returns the router connection id.
+	register := func(t *testing.T, c *Service, ttl int64) (string, error) {
+		t.Helper()
+
+		txnID := uuid.New().String()
+
+		_, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		msgMap, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes, TTL: ttl},
+		})})
+		if err != nil {
+			return "", err
+		}
+
+		resp := &ConnResp{}
+		require.NoError(t, msgMap.Decode(resp))
 
-				didDoc, dErr := did.ParseDocument(pMsg.Data.DIDDoc)
-				require.NoError(t, dErr)
+		return resp.Data.ConnectionID, nil
+	}
 
-				require.Contains(t, didDoc.ID, "did:")
-				require.Equal(t, pMsg.Type, didDocResp)
+	newService := func(t *testing.T, connID string) (*Service, *fakeClock, chan string) {
+		t.Helper()
 
-				done <- struct{}{}
+		unregistered := make(chan string, 1)
+		clock := &fakeClock{now: time.Now()}
+
+		config := config()
+		config.Clock = clock
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDs: connID}
+		config.MediatorClient = &mockmediator.MockClient{
+			UnregisterFunc: func(connectionID string) error {
+				unregistered <- connectionID
 
 				return nil
 			},
 		}
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		c, err := New(config)
+		require.NoError(t, err)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
-			ID:   uuid.New().String(),
-			Type: didDocReq,
-		})}
+		t.Cleanup(func() { require.NoError(t, c.Close(context.Background())) })
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
-	})
+		return c, clock, unregistered
+	}
 
-	t.Run("register route request", func(t *testing.T) {
+	t.Run("route unregistered once expired", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		connID := uuid.New().String()
+		c, clock, unregistered := newService(t, connID)
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
+		routerConnID, err := register(t, c, 60)
+		require.NoError(t, err)
 
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Empty(t, pMsg.Data)
+		clock.advance(30 * time.Second)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Empty(t, unregistered)
 
-				done <- struct{}{}
+		_, err = c.store.Get(connID)
+		require.NoError(t, err)
 
-				return nil
-			},
-		}
+		clock.advance(time.Minute)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Equal(t, routerConnID, <-unregistered)
 
-		c, err := New(config)
-		require.NoError(t, err)
+		_, err = c.store.Get(connID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		_, err = c.store.Get(routeExpiryKey(connID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
 
-		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
-		txnID := uuid.New().String()
+	t.Run("route without ttl doesn't expire", func(t *testing.T) {
+		t.Parallel()
 
-		err = c.store.Put(txnID, []byte(didDoc.ID))
-		require.NoError(t, err)
+		connID := uuid.New().String()
+		c, clock, unregistered := newService(t, connID)
 
-		didDocBytes, err := didDoc.JSONBytes()
+		_, err := register(t, c, 0)
 		require.NoError(t, err)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: txnID,
-			},
-			Data: &ConnReqData{
-				DIDDoc: didDocBytes,
-			},
-		})}
+		_, err = c.store.Get(routeExpiryKey(connID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+		clock.advance(24 * time.Hour)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Empty(t, unregistered)
 	})
-}
 
-func TestDIDDocReq(t *testing.T) {
-	t.Parallel()
+	t.Run("route unregistered by the client", func(t *testing.T) {
+		t.Parallel()
 
-	t.Run("create did doc vm error", func(t *testing.T) {
+		connID := uuid.New().String()
+		c, _, unregistered := newService(t, connID)
+
+		_, err := register(t, c, 60)
+		require.NoError(t, err)
+
+		_, err = c.HandleUnregisterRouteReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(
+			UnregisterRouteReq{ID: uuid.New().String(), Type: UnregisterRouteReqMsgType})})
+		require.NoError(t, err)
+		<-unregistered
+
+		_, err = c.store.Get(routeExpiryKey(connID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("registered again without ttl", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		connID := uuid.New().String()
+		c, clock, unregistered := newService(t, connID)
 
-		expectErr := "expected err"
+		_, err := register(t, c, 60)
+		require.NoError(t, err)
 
-		config.KeyManager = &mockkms.KeyManager{CrAndExportPubKeyErr: errors.New(expectErr)}
+		routerConnID, err := register(t, c, 0)
+		require.NoError(t, err)
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, didDocResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, expectErr)
+		_, err = c.store.Get(routeExpiryKey(connID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
 
-				done <- struct{}{}
+		clock.advance(2 * time.Minute)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Empty(t, unregistered)
 
-				return nil
-			},
-		}
+		mapped, err := c.store.Get(connID)
+		require.NoError(t, err)
+		require.Equal(t, routerConnID, string(mapped))
+	})
 
-		c, err := New(config)
+	t.Run("expiry of a replaced route", func(t *testing.T) {
+		t.Parallel()
+
+		connID := uuid.New().String()
+		c, clock, unregistered := newService(t, connID)
+
+		_, err := register(t, c, 60)
 		require.NoError(t, err)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		// registered again since, on another router connection
+		require.NoError(t, c.store.Put(connID, []byte("other-router-conn")))
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
-			ID:   uuid.New().String(),
-			Type: didDocReq,
-		})}
+		clock.advance(2 * time.Minute)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Empty(t, unregistered)
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+		mapped, err := c.store.Get(connID)
+		require.NoError(t, err)
+		require.Equal(t, "other-router-conn", string(mapped))
+
+		_, err = c.store.Get(routeExpiryKey(connID))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
 	})
 
-	t.Run("create did doc keyagreement vm error", func(t *testing.T) {
+	t.Run("unregister error doesn't stop the sweep", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		var unregistered []string
 
-		config.KeyAgrType = "foo"
+		clock := &fakeClock{now: time.Now()}
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, didDocResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "failed to create new keyagreement VM")
+		config := config()
+		config.Clock = clock
+		config.MediatorClient = &mockmediator.MockClient{
+			UnregisterFunc: func(connectionID string) error {
+				if connectionID == "router-conn-1" {
+					return errors.New("unregister error")
+				}
 
-				done <- struct{}{}
+				unregistered = append(unregistered, connectionID)
 
 				return nil
 			},
@@ -284,37 +4755,40 @@ func TestDIDDocReq(t *testing.T) {
 		c, err := New(config)
 		require.NoError(t, err)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
-			ID:   uuid.New().String(),
-			Type: didDocReq,
-		})}
+		expired := storage.Tag{Name: routeExpiresTagName, Value: strconv.FormatInt(clock.Now().UnixNano(), 10)}
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
+		for _, route := range []struct{ connID, routerConnID string }{
+			{"conn-1", "router-conn-1"}, {"conn-2", "router-conn-2"},
+		} {
+			require.NoError(t, c.store.Put(route.connID, []byte(route.routerConnID)))
+			require.NoError(t, c.store.Put(routeExpiryKey(route.connID), []byte(route.routerConnID), expired))
 		}
+
+		clock.advance(time.Second)
+		require.NoError(t, c.unregisterExpiredRoutes(clock.Now()))
+		require.Equal(t, []string{"router-conn-2"}, unregistered)
+
+		// the failed one is tried again on the next sweep
+		_, err = c.store.Get(routeExpiryKey("conn-1"))
+		require.NoError(t, err)
+
+		_, err = c.store.Get(routeExpiryKey("conn-2"))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
 	})
 
-	t.Run("create did doc error", func(t *testing.T) {
+	t.Run("ttl shorter than the txn ttl", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
-
-		done := make(chan struct{})
-		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, didDocResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "create did error")
+		unregistered := make(chan string, 1)
 
-				done <- struct{}{}
+		config := config()
+		config.TxnTTL = time.Hour
+		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDs: uuid.New().String()}
+		config.MediatorClient = &mockmediator.MockClient{
+			UnregisterFunc: func(connectionID string) error {
+				unregistered <- connectionID
 
 				return nil
 			},
@@ -323,402 +4797,615 @@ func TestDIDDocReq(t *testing.T) {
 		c, err := New(config)
 		require.NoError(t, err)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
-			ID:   uuid.New().String(),
-			Type: didDocReq,
-		})}
+		routerConnID, err := register(t, c, 1)
+		require.NoError(t, err)
 
 		select {
-		case <-done:
+		case connID := <-unregistered:
+			require.Equal(t, routerConnID, connID)
 		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
+			require.Fail(t, "route not unregistered by its expiry")
 		}
 	})
 
-	t.Run("store error", func(t *testing.T) {
+	t.Run("negative ttl", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		c, _, _ := newService(t, uuid.New().String())
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, didDocResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "save txn data")
+		_, err := register(t, c, -1)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeMsgParse, errorCode(err))
+	})
+}
 
-				done <- struct{}{}
+func TestReturnRoute(t *testing.T) {
+	t.Parallel()
 
-				return nil
-			},
+	for expected, transport := range map[bool][]interface{}{
+		true: {
+			map[string]interface{}{"~return_route": decorator.TransportReturnRouteAll},
+			map[string]interface{}{"~return_route": decorator.TransportReturnRouteThread},
+		},
+		false: {
+			nil,
+			map[string]interface{}{"~return_route": decorator.TransportReturnRouteNone},
+			map[string]interface{}{"~return_route": "unknown"},
+			map[string]interface{}{},
+			"invalid",
+		},
+	} {
+		for _, tr := range transport {
+			msg := service.NewDIDCommMsgMap(DIDDocReq{ID: uuid.New().String(), Type: DIDDocReqMsgType})
+			if tr != nil {
+				msg["~transport"] = tr
+			}
+
+			require.Equal(t, expected, returnRoute(msg), "%v", tr)
 		}
+	}
+}
 
-		c, err := New(config)
-		require.NoError(t, err)
+func TestAllowedPeerDIDMethods(t *testing.T) {
+	t.Parallel()
 
-		c.store = &mockstorage.Store{ErrPut: errors.New("save error")}
+	// register runs a diddoc-req and its register-route-req with the mock did doc, a did:peer, and reports
+	// whether a connection was created.
+	register := func(t *testing.T, allowed ...string) (bool, error) {
+		t.Helper()
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		var created bool
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(DIDDocReq{
-			ID:   uuid.New().String(),
-			Type: didDocReq,
-		})}
+		config := config()
+		config.AllowedPeerDIDMethods = allowed
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				created = true
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
+				return uuid.New().String(), nil
+			},
 		}
-	})
-}
 
-func TestRegisterRouteReq(t *testing.T) { // nolint:gocyclo,cyclop
-	t.Parallel()
+		c, err := New(config)
+		require.NoError(t, err)
 
-	t.Run("missing parent thread id", func(t *testing.T) {
-		t.Parallel()
+		defer func() { require.NoError(t, c.Close(context.Background())) }()
 
-		c, err := New(config())
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
 		require.NoError(t, err)
 
-		done := make(chan struct{})
-		c.messenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "parent thread id mandatory")
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
 
-				done <- struct{}{}
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
 
-				return nil
-			},
-		}
+		return created, err
+	}
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+	t.Run("all methods allowed by default", func(t *testing.T) {
+		t.Parallel()
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-		})}
+		created, err := register(t)
+		require.NoError(t, err)
+		require.True(t, created)
+	})
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+	t.Run("allowed method", func(t *testing.T) {
+		t.Parallel()
+
+		created, err := register(t, "peer", "key")
+		require.NoError(t, err)
+		require.True(t, created)
 	})
 
-	t.Run("empty did doc in the request", func(t *testing.T) {
+	t.Run("rejected method", func(t *testing.T) {
 		t.Parallel()
 
+		created, err := register(t, "key", "web")
+		require.Error(t, err)
+		require.Equal(t, ErrCodeDIDMethodNotAllowed, errorCode(err))
+		require.EqualError(t, err, "did method policy : did method peer is not allowed, expected one of key, web")
+		require.False(t, created)
+	})
+}
+
+func TestAdapterDID(t *testing.T) {
+	t.Parallel()
+
+	// newService returns a service whose router dids are unique, and a func running a diddoc-req that returns the
+	// txn id and the router did doc.
+	newService := func(t *testing.T) (*Service, func() (string, []byte)) {
+		t.Helper()
+
 		config := config()
+		config.VDRIRegistry = &mockvdr.MockVDRegistry{
+			CreateFunc: func(string, *did.Doc, ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+				doc := mockdiddoc.GetMockDIDDoc(t, false)
+				doc.ID = "did:peer:" + uuid.New().String()
+
+				return &did.DocResolution{DIDDocument: doc}, nil
+			},
+		}
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return uuid.New().String(), nil
+			},
+		}
 
 		c, err := New(config)
 		require.NoError(t, err)
 
-		done := make(chan struct{})
-		c.messenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "did document mandatory")
+		t.Cleanup(func() { require.NoError(t, c.Close(context.Background())) })
 
-				done <- struct{}{}
+		return c, func() (string, []byte) {
+			txnID := uuid.New().String()
 
-				return nil
-			},
+			msgMap, err := c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+				ID:   txnID,
+				Type: DIDDocReqMsgType,
+			}))
+			require.NoError(t, err)
+
+			resp := &DIDDocResp{}
+			require.NoError(t, msgMap.Decode(resp))
+
+			return txnID, resp.Data.DIDDoc
 		}
+	}
+
+	register := func(c *Service, txnID string, didDoc []byte) error {
+		_, err := c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDoc},
+		})})
+
+		return err
+	}
+
+	t.Run("client did", func(t *testing.T) {
+		t.Parallel()
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		c, didDocReq := newService(t)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: uuid.New().String(),
-			},
-		})}
+		txnID, _ := didDocReq()
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		require.NoError(t, register(c, txnID, didDocBytes))
 	})
 
-	t.Run("invalid did doc in the request", func(t *testing.T) {
+	t.Run("router did of the transaction", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		c, didDocReq := newService(t)
 
-		done := make(chan struct{})
-		config.VDRIRegistry = &mockvdr.MockVDRegistry{CreateErr: errors.New("create did error")}
+		txnID, routerDIDDoc := didDocReq()
 
-		c, err := New(config)
+		routerDID, err := did.ParseDocument(routerDIDDoc)
 		require.NoError(t, err)
 
-		c.messenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "parse did doc")
+		err = register(c, txnID, routerDIDDoc)
+		require.EqualError(t, err, fmt.Sprintf("did %s is the adapter's, not the client's", routerDID.ID))
+		require.Equal(t, ErrCodeAdapterDID, errorCode(err))
+	})
 
-				done <- struct{}{}
+	t.Run("router did of another transaction", func(t *testing.T) {
+		t.Parallel()
 
-				return nil
-			},
-		}
+		c, didDocReq := newService(t)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		_, otherRouterDIDDoc := didDocReq()
+		txnID, _ := didDocReq()
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: uuid.New().String(),
-			},
-			Data: &ConnReqData{
-				DIDDoc: []byte("invalid-did-doc"),
-			},
-		})}
+		otherRouterDID, err := did.ParseDocument(otherRouterDIDDoc)
+		require.NoError(t, err)
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+		err = register(c, txnID, otherRouterDIDDoc)
+		require.EqualError(t, err, fmt.Sprintf("did %s is an adapter router did, not the client's", otherRouterDID.ID))
+		require.Equal(t, ErrCodeAdapterDID, errorCode(err))
 	})
 
-	t.Run("store error", func(t *testing.T) {
+	t.Run("router did markers outlive the txns", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
+		c, didDocReq := newService(t)
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "fetch txn data")
+		_, otherRouterDIDDoc := didDocReq()
 
-				done <- struct{}{}
+		otherRouterDID, err := did.ParseDocument(otherRouterDIDDoc)
+		require.NoError(t, err)
 
-				return nil
-			},
-		}
+		require.NoError(t, c.deleteExpiredTxns(time.Now().Add(time.Hour)))
 
-		c, err := New(config)
+		_, err = c.store.Get(routerDIDKey(otherRouterDID.ID))
 		require.NoError(t, err)
 
-		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
-		didDocBytes, err := didDoc.JSONBytes()
-		require.NoError(t, err)
+		txnID, _ := didDocReq()
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		err = register(c, txnID, otherRouterDIDDoc)
+		require.EqualError(t, err, fmt.Sprintf("did %s is an adapter router did, not the client's", otherRouterDID.ID))
+		require.Equal(t, ErrCodeAdapterDID, errorCode(err))
+	})
+}
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: uuid.New().String(),
-			},
-			Data: &ConnReqData{
-				DIDDoc: didDocBytes,
-			},
-		})}
+func TestTransformIncomingDIDDoc(t *testing.T) {
+	t.Parallel()
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
-	})
+	// register runs a diddoc-req and its register-route-req with the mock did doc, and returns the doc the
+	// connection was created with, if any.
+	register := func(t *testing.T, transform func(*did.Doc) (*did.Doc, error),
+		allowed ...string) (*Service, *did.Doc, []byte, error) {
+		t.Helper()
 
-	t.Run("create connection error", func(t *testing.T) {
-		t.Parallel()
+		var theirDID *did.Doc
 
 		config := config()
+		config.TransformIncomingDIDDoc = transform
+		config.AllowedPeerDIDMethods = allowed
 		config.DIDExchangeClient = &mockdidex.MockClient{
-			CreateConnectionFunc: func(s string, doc *did.Doc, option ...didexchange.ConnectionOption) (string, error) {
-				return "", errors.New("create conn error")
-			},
-		}
-
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "create connection")
-
-				done <- struct{}{}
+			CreateConnectionFunc: func(_ string, doc *did.Doc, _ ...didexchange.ConnectionOption) (string, error) {
+				theirDID = doc
 
-				return nil
+				return uuid.New().String(), nil
 			},
 		}
 
 		c, err := New(config)
 		require.NoError(t, err)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+		t.Cleanup(func() { require.NoError(t, c.Close(context.Background())) })
 
-		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
 		txnID := uuid.New().String()
 
-		err = c.store.Put(txnID, []byte(didDoc.ID))
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
 		require.NoError(t, err)
 
-		didDocBytes, err := didDoc.JSONBytes()
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
 		require.NoError(t, err)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: txnID,
-			},
-			Data: &ConnReqData{
-				DIDDoc: didDocBytes,
-			},
-		})}
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
+		return c, theirDID, didDocBytes, err
+	}
+
+	t.Run("transformed doc used for the connection", func(t *testing.T) {
+		t.Parallel()
+
+		c, theirDID, didDocBytes, err := register(t, func(doc *did.Doc) (*did.Doc, error) {
+			for i := range doc.Service {
+				doc.Service[i].Accept = []string{"didcomm/aip2;env=rfc19"}
+			}
+
+			return doc, nil
+		})
+		require.NoError(t, err)
+		require.NotNil(t, theirDID)
+		require.NotEmpty(t, theirDID.Service)
+
+		for _, svc := range theirDID.Service {
+			require.Equal(t, []string{"didcomm/aip2;env=rfc19"}, svc.Accept)
+		}
+
+		// the hook modified its own copy, not the cached doc
+		cached, err := c.didDocs.parse(didDocBytes)
+		require.NoError(t, err)
+
+		for _, svc := range cached.Service {
+			require.Empty(t, svc.Accept)
 		}
 	})
 
-	t.Run("register route error", func(t *testing.T) {
+	t.Run("transform error", func(t *testing.T) {
 		t.Parallel()
 
-		config := config()
-		config.MediatorClient = &mockmediator.MockClient{
-			RegisterErr: errors.New("register route error"),
-		}
+		_, theirDID, _, err := register(t, func(*did.Doc) (*did.Doc, error) {
+			return nil, errors.New("unsupported key type")
+		})
+		require.EqualError(t, err, "transform did doc : unsupported key type")
+		require.Equal(t, ErrCodeDIDDocTransform, errorCode(err))
+		require.Nil(t, theirDID)
+	})
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "route registration")
+	t.Run("no doc returned", func(t *testing.T) {
+		t.Parallel()
 
-				done <- struct{}{}
+		_, theirDID, _, err := register(t, func(*did.Doc) (*did.Doc, error) {
+			return nil, nil
+		})
+		require.EqualError(t, err, "transform did doc : no did doc returned")
+		require.Equal(t, ErrCodeDIDDocTransform, errorCode(err))
+		require.Nil(t, theirDID)
+	})
 
-				return nil
-			},
-		}
+	t.Run("invalid transformed doc", func(t *testing.T) {
+		t.Parallel()
 
-		c, err := New(config)
-		require.NoError(t, err)
+		_, theirDID, _, err := register(t, func(doc *did.Doc) (*did.Doc, error) {
+			doc.Service = nil
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
+			return doc, nil
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "validate transformed did doc : ")
+		require.Equal(t, ErrCodeDIDDocInvalid, errorCode(err))
+		require.Nil(t, theirDID)
+	})
 
-		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
-		txnID := uuid.New().String()
+	t.Run("transformed doc with a method not allowed", func(t *testing.T) {
+		t.Parallel()
 
-		err = c.store.Put(txnID, []byte(didDoc.ID))
-		require.NoError(t, err)
+		_, theirDID, _, err := register(t, func(doc *did.Doc) (*did.Doc, error) {
+			doc.ID = "did:web:evil.com"
 
-		didDocBytes, err := didDoc.JSONBytes()
+			return doc, nil
+		}, "peer")
+		require.EqualError(t, err, "did method policy : did method web is not allowed, expected one of peer")
+		require.Equal(t, ErrCodeDIDMethodNotAllowed, errorCode(err))
+		require.Nil(t, theirDID)
+	})
+}
+
+func TestMsgExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		timing string
+		errMsg string
+		code   string
+	}{
+		"no timing decorator": {},
+		"unexpired": {
+			timing: `,"~timing":{"expires_time":"2021-06-01T12:05:00Z"}`,
+		},
+		"already expired": {
+			timing: `,"~timing":{"expires_time":"2021-06-01T11:59:00Z"}`,
+			errMsg: "message expired at 2021-06-01T11:59:00Z",
+			code:   ErrCodeMsgExpired,
+		},
+		"expires before processing can complete": {
+			timing: `,"~timing":{"expires_time":"2021-06-01T12:00:00.5Z"}`,
+			errMsg: "message expired at 2021-06-01T12:00:00Z",
+			code:   ErrCodeMsgExpired,
+		},
+		"invalid expiry": {
+			timing: `,"~timing":{"expires_time":"tomorrow"}`,
+			code:   ErrCodeMsgParse,
+		},
+	} {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var created bool
+
+			config := config()
+			config.Clock = &fakeClock{now: now}
+			config.VDRIRegistry = &mockvdr.MockVDRegistry{
+				CreateFunc: func(string, *did.Doc, ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+					created = true
+
+					return &did.DocResolution{DIDDocument: mockdiddoc.GetMockDIDDoc(t, false)}, nil
+				},
+			}
+
+			c, err := New(config)
+			require.NoError(t, err)
+
+			defer func() { require.NoError(t, c.Close(context.Background())) }()
+
+			msg, err := service.ParseDIDCommMsgMap([]byte(
+				`{"@id":"` + uuid.New().String() + `","@type":"` + DIDDocReqMsgType + `"` + tc.timing + `}`))
+			require.NoError(t, err)
+
+			_, err = c.callHandler(context.Background(), message.Msg{DIDCommMsg: msg})
+			if tc.code == "" {
+				require.NoError(t, err)
+				require.True(t, created)
+
+				return
+			}
+
+			require.Error(t, err)
+			require.Equal(t, tc.code, errorCode(err))
+			require.False(t, created, "router did created for an expired message")
+
+			if tc.errMsg != "" {
+				require.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestConnReqValidation(t *testing.T) {
+	t.Parallel()
+
+	c, err := New(config())
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		payload string
+		code    string
+		errMsg  string
+	}{
+		"unexpected message type": {
+			payload: `{"@id":"1","@type":"` + DIDDocReqMsgType + `"}`,
+			code:    ErrCodeUnsupportedMsgType,
+			errMsg:  "unexpected message type " + DIDDocReqMsgType + ", expected " + RegisterRouteReqMsgType,
+		},
+		"id not a string": {
+			payload: `{"@id":5,"@type":"` + RegisterRouteReqMsgType + `"}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field @id must be a string, not float64",
+		},
+		"missing id": {
+			payload: `{"@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},"data":{"didDoc":{}}}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "message id mandatory",
+		},
+		"data not an object": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","data":"did doc"}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field data must be an object, not string",
+		},
+		"dry run not a boolean": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","data":{"dryRun":"true"}}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field data.dryRun must be a boolean, not string",
+		},
+		"label not a string": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","data":{"label":5}}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field data.label must be a string, not float64",
+		},
+		"thread not an object": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":["txn-1"]}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field ~thread must be an object, not []interface {}",
+		},
+		"parent thread id not a string": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":true}}`,
+			code:    ErrCodeMsgParse,
+			errMsg:  "parse didcomm message : field ~thread.pthid must be a string, not bool",
+		},
+		"missing parent thread id": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","data":{"didDoc":{}}}`,
+			code:    ErrCodeParentThreadIDMissing,
+			errMsg:  "parent thread id mandatory",
+		},
+		"missing data": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"}}`,
+			code:    ErrCodeDIDDocMissing,
+			errMsg:  "data mandatory",
+		},
+		"missing did doc": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},"data":{}}`,
+			code:    ErrCodeDIDDocMissing,
+			errMsg:  "did document mandatory",
+		},
+		"invalid did doc": {
+			payload: `{"@id":"1","@type":"` + RegisterRouteReqMsgType + `","~thread":{"pthid":"txn-1"},` +
+				`"data":{"didDoc":{"id":5}}}`,
+			code:   ErrCodeDIDDocInvalid,
+			errMsg: "parse did doc",
+		},
+	} {
+		msg, err := service.ParseDIDCommMsgMap([]byte(tc.payload))
+		require.NoError(t, err, name)
+
+		_, err = c.handleRouteRegistration(context.Background(), message.Msg{DIDCommMsg: msg})
+		require.Error(t, err, name)
+		require.Equal(t, tc.code, errorCode(err), name)
+		require.Contains(t, err.Error(), tc.errMsg, name)
+	}
+}
+
+func TestDIDDocSize(t *testing.T) {
+	t.Parallel()
+
+	didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+	require.NoError(t, err)
+
+	connReq := func(doc json.RawMessage) (message.Msg, int) {
+		msg := service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: uuid.New().String()},
+			Data:   &ConnReqData{DIDDoc: doc},
+		})
+
+		pMsg := &ConnReq{}
+		require.NoError(t, msg.Decode(pMsg))
+
+		return message.Msg{DIDCommMsg: msg}, len(pMsg.Data.DIDDoc)
+	}
+
+	t.Run("empty did doc", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(config())
 		require.NoError(t, err)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: txnID,
-			},
-			Data: &ConnReqData{
-				DIDDoc: didDocBytes,
-			},
-		})}
+		for _, doc := range []string{`null`, `""`} {
+			msg, _ := connReq(json.RawMessage(doc))
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
+			_, err = c.handleRouteRegistration(context.Background(), msg)
+			require.Error(t, err, doc)
+			require.Equal(t, ErrCodeDIDDocMissing, errorCode(err), doc)
+			require.Contains(t, err.Error(), "did document must not be empty", doc)
 		}
 	})
 
-	t.Run("connection id look up error", func(t *testing.T) {
+	t.Run("at the limit", func(t *testing.T) {
 		t.Parallel()
 
+		msg, size := connReq(didDocBytes)
+
 		config := config()
-		config.ConnectionLookup = &mockconn.MockConnectionsLookup{ConnIDByDIDsErr: errors.New("lookup error")}
+		config.MaxDIDDocSize = size
 
-		done := make(chan struct{})
-		config.AriesMessenger = &messenger.MockMessenger{
-			ReplyToFunc: func(msgID string, msg service.DIDCommMsgMap, _ ...service.Opt) error {
-				pMsg := &ErrorResp{}
-				dErr := msg.Decode(pMsg)
-				require.NoError(t, dErr)
-				require.Equal(t, pMsg.Type, registerRouteResp)
-				require.Contains(t, pMsg.Data.ErrorMsg, "get connection by dids")
+		c, err := New(config)
+		require.NoError(t, err)
 
-				done <- struct{}{}
+		// the size check passes, the txn lookup fails as no diddoc-req was handled
+		_, err = c.handleRouteRegistration(context.Background(), msg)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeTxnNotFound, errorCode(err))
+	})
 
-				return nil
-			},
-		}
+	t.Run("over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		msg, size := connReq(didDocBytes)
+
+		config := config()
+		config.MaxDIDDocSize = size - 1
 
 		c, err := New(config)
 		require.NoError(t, err)
 
-		msgCh := make(chan message.Msg, 1)
-		go c.didCommMsgListener(msgCh)
-
-		didDoc := mockdiddoc.GetMockDIDDoc(t, false)
-		txnID := uuid.New().String()
+		_, err = c.handleRouteRegistration(context.Background(), msg)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeDIDDocTooLarge, errorCode(err))
+		require.EqualError(t, err, fmt.Sprintf("did document size %d exceeds the limit of %d bytes", size, size-1))
+	})
 
-		err = c.store.Put(txnID, []byte(didDoc.ID))
-		require.NoError(t, err)
+	t.Run("default limit", func(t *testing.T) {
+		t.Parallel()
 
-		didDocBytes, err := didDoc.JSONBytes()
+		c, err := New(config())
 		require.NoError(t, err)
 
-		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
-			ID:   uuid.New().String(),
-			Type: registerRouteReq,
-			Thread: &decorator.Thread{
-				PID: txnID,
-			},
-			Data: &ConnReqData{
-				DIDDoc: didDocBytes,
-			},
-		})}
+		msg, _ := connReq(json.RawMessage(`"` + strings.Repeat("a", defaultMaxDIDDocSize) + `"`))
 
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			require.Fail(t, "tests are not validated due to timeout")
-		}
+		_, err = c.handleRouteRegistration(context.Background(), msg)
+		require.Error(t, err)
+		require.Equal(t, ErrCodeDIDDocTooLarge, errorCode(err))
 	})
 }
 
@@ -1128,3 +5815,16 @@ func TestService_newVerificationMethod(t *testing.T) {
 		require.Contains(t, err.Error(), "creating jwk")
 	})
 }
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+
+	expected := errors.New("expected")
+
+	err := fmt.Errorf("wrapped : %w", withCode(ErrCodeTxnFetch, expected))
+	require.Equal(t, ErrCodeTxnFetch, errorCode(err))
+	require.ErrorIs(t, err, expected)
+	require.EqualError(t, err, "wrapped : expected")
+
+	require.Equal(t, ErrCodeInternal, errorCode(expected))
+}
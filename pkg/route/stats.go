@@ -0,0 +1,83 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the message listener activity.
+type Stats struct {
+	// InFlight is the number of received messages not replied to yet, including the ones waiting for a handler.
+	InFlight int
+	// Processed is the number of messages handled since the service started, failed ones included.
+	Processed uint64
+	// Errored is the number of messages whose handling or reply failed.
+	Errored uint64
+	// OldestInFlight is the age of the oldest message in flight, zero when there is none. A growing value while
+	// InFlight stays at MaxConcurrentHandlers means the handlers can't keep up or are stalled.
+	OldestInFlight time.Duration
+}
+
+// listenerStats tracks the messages from dispatch to reply.
+type listenerStats struct {
+	mu        sync.Mutex
+	nextID    uint64
+	inFlight  map[uint64]time.Time
+	processed uint64
+	errored   uint64
+}
+
+func newListenerStats() *listenerStats {
+	return &listenerStats{inFlight: map[uint64]time.Time{}}
+}
+
+// start records a message received at the given time and returns its id for done.
+func (s *listenerStats) start(received time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	s.inFlight[s.nextID] = received
+
+	return s.nextID
+}
+
+func (s *listenerStats) done(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, id)
+	s.processed++
+}
+
+func (s *listenerStats) failed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errored++
+}
+
+func (s *listenerStats) snapshot(now time.Time) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		InFlight:  len(s.inFlight),
+		Processed: s.processed,
+		Errored:   s.errored,
+	}
+
+	for _, received := range s.inFlight {
+		if age := now.Sub(received); age > stats.OldestInFlight {
+			stats.OldestInFlight = age
+		}
+	}
+
+	return stats
+}
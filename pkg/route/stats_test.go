@@ -0,0 +1,111 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	"github.com/trustbloc/edge-adapter/pkg/internal/mock/messenger"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	t.Run("processed and errored messages", func(t *testing.T) {
+		t.Parallel()
+
+		replies := make(chan struct{}, 4)
+
+		config := config()
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				replies <- struct{}{}
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+		require.Equal(t, Stats{}, c.Stats())
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		for i := 0; i < 3; i++ {
+			msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType})}
+		}
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: "unsupported"})}
+
+		for i := 0; i < 4; i++ {
+			select {
+			case <-replies:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "tests are not validated due to timeout")
+			}
+		}
+
+		require.Eventually(t, func() bool {
+			return c.Stats() == Stats{Processed: 4, Errored: 1}
+		}, 5*time.Second, 10*time.Millisecond, "%+v", c.Stats())
+	})
+
+	t.Run("messages in flight", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Now()}
+		release := make(chan struct{})
+
+		config := config()
+		config.Clock = clock
+		config.MaxConcurrentHandlers = 1
+		config.AriesMessenger = &messenger.MockMessenger{
+			ReplyToFunc: func(string, service.DIDCommMsgMap, ...service.Opt) error {
+				<-release
+
+				return nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		msgCh := make(chan message.Msg, 1)
+		go c.didCommMsgListener(msgCh)
+
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType})}
+
+		require.Eventually(t, func() bool {
+			return c.Stats().InFlight == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		clock.advance(time.Minute)
+
+		// waits for the handler slot, it is in flight too
+		msgCh <- message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(Ping{ID: uuid.New().String(), Type: PingMsgType})}
+
+		require.Eventually(t, func() bool {
+			return c.Stats().InFlight == 2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		clock.advance(time.Second)
+		require.Equal(t, Stats{InFlight: 2, OldestInFlight: time.Minute + time.Second}, c.Stats())
+
+		close(release)
+
+		require.Eventually(t, func() bool {
+			return c.Stats() == Stats{Processed: 2}
+		}, 5*time.Second, 10*time.Millisecond, "%+v", c.Stats())
+	})
+}
@@ -7,7 +7,12 @@ SPDX-License-Identifier: Apache-2.0
 package route
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
@@ -21,6 +26,7 @@ import (
 	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/stretchr/testify/require"
 
 	mockconn "github.com/trustbloc/edge-adapter/pkg/internal/mock/connection"
@@ -46,6 +52,66 @@ func config() *Config {
 	}
 }
 
+// recordingAuditStore keeps the audit records, or fails with err.
+type recordingAuditStore struct {
+	mu      sync.Mutex
+	records []*DIDCreatedRecord
+	err     error
+}
+
+func (s *recordingAuditStore) RecordDIDCreated(_ context.Context, record *DIDCreatedRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.records = append(s.records, record)
+
+	return nil
+}
+
+func (s *recordingAuditStore) recorded() []*DIDCreatedRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*DIDCreatedRecord(nil), s.records...)
+}
+
+// mockMediatorConfig is a mediator client that counts the Config calls.
+type mockMediatorConfig struct {
+	mockmediator.MockClient
+	mu          sync.Mutex
+	routingKeys []string
+	endpoint    string
+	err         error
+	calls       int
+}
+
+func (m *mockMediatorConfig) Config() ([]string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+
+	return m.routingKeys, m.endpoint, m.err
+}
+
+func (m *mockMediatorConfig) set(endpoint string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.endpoint, m.err = endpoint, err
+}
+
+func (m *mockMediatorConfig) configCalls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.calls
+}
+
 func getDIDDoc() *did.Doc {
 	return &did.Doc{
 		Service: []did.Service{
@@ -87,3 +153,216 @@ func realKMS(t *testing.T) kms.KeyManager {
 
 	return keyManager
 }
+
+type failingDeleteStore struct {
+	storage.Store
+	err error
+}
+
+func (s *failingDeleteStore) Delete(string) error {
+	return s.err
+}
+
+// failingPutStore fails the Put of the keys with the prefix.
+type failingPutStore struct {
+	storage.Store
+	prefix string
+	err    error
+}
+
+func (s *failingPutStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if strings.HasPrefix(key, s.prefix) {
+		return s.err
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+// staleGetStore returns a fixed value from Get, whatever was put.
+type staleGetStore struct {
+	storage.Store
+}
+
+func (s *staleGetStore) Get(string) ([]byte, error) {
+	return []byte("stale"), nil
+}
+
+// blockingStore blocks Put and Get until release is closed.
+type blockingStore struct {
+	storage.Store
+	release chan struct{}
+}
+
+func (s *blockingStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	<-s.release
+
+	return s.Store.Put(key, value, tags...)
+}
+
+func (s *blockingStore) Get(key string) ([]byte, error) {
+	<-s.release
+
+	return s.Store.Get(key)
+}
+
+// deleteRecordingStore sends the keys it deletes on deleted.
+type deleteRecordingStore struct {
+	storage.Store
+	deleted chan string
+}
+
+func (s *deleteRecordingStore) Delete(key string) error {
+	err := s.Store.Delete(key)
+
+	s.deleted <- key
+
+	return err
+}
+
+// flakyStore fails the first failures Put and Get calls with a temporary error and counts the calls.
+type flakyStore struct {
+	storage.Store
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) fail() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+
+	if s.failures > 0 {
+		s.failures--
+
+		return temporaryErr{}
+	}
+
+	return nil
+}
+
+func (s *flakyStore) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+func (s *flakyStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+func (s *flakyStore) Get(key string) ([]byte, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.Store.Get(key)
+}
+
+type mockMetrics struct {
+	mu        sync.Mutex
+	received  map[string]int
+	errors    map[string]int
+	durations map[string]int
+}
+
+func newMockMetrics() *mockMetrics {
+	return &mockMetrics{
+		received:  map[string]int{},
+		errors:    map[string]int{},
+		durations: map[string]int{},
+	}
+}
+
+func (m *mockMetrics) IncMessageReceived(msgType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.received[msgType]++
+}
+
+func (m *mockMetrics) IncMessageError(msgType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors[msgType]++
+}
+
+func (m *mockMetrics) ObserveHandlerDuration(msgType string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.durations[msgType]++
+}
+
+// recordingLogger keeps the error logs.
+type recordingLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.debugs = append(l.debugs, fmt.Sprintf(msg, args...))
+}
+
+func (l *recordingLogger) Infof(string, ...interface{}) {}
+
+func (l *recordingLogger) Warnf(string, ...interface{}) {}
+
+func (l *recordingLogger) Errorf(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, fmt.Sprintf(msg, args...))
+}
+
+func (l *recordingLogger) errorLogs() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.errors...)
+}
+
+func (l *recordingLogger) debugLogs() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.debugs...)
+}
+
+// temporaryErr is an error reporting itself as temporary.
+type temporaryErr struct{}
+
+func (temporaryErr) Error() string { return "temporarily unavailable" }
+
+func (temporaryErr) Temporary() bool { return true }
+
+// fakeClock is a Clock that only moves forward when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the default tracer.
+const tracerName = "github.com/trustbloc/edge-adapter/pkg/route"
+
+// Span names.
+const (
+	spanDIDDocReq          = "diddoc-req"
+	spanRegisterRouteReq   = "register-route-req"
+	spanCreateDID          = "create router did"
+	spanCreateConnection   = "create connection"
+	spanRegisterRoute      = "register route"
+	spanUnregisterRouteReq = "unregister-route-req"
+)
+
+// Span attribute keys.
+const (
+	attrMsgType            = attribute.Key("msg.type")
+	attrMsgID              = attribute.Key("msg.id")
+	attrParentThreadID     = attribute.Key("msg.pthid")
+	attrDIDMethod          = attribute.Key("did.method")
+	attrConnectionID       = attribute.Key("connection.id")
+	attrRouterConnectionID = attribute.Key("router.connection.id")
+	attrErrorCode          = attribute.Key("error.code")
+)
+
+// msgSpanAttrs returns the span attributes identifying the message.
+func msgSpanAttrs(msg service.DIDCommMsg) trace.SpanStartEventOption {
+	attrs := []attribute.KeyValue{attrMsgType.String(msg.Type()), attrMsgID.String(msg.ID())}
+
+	if pthid := msg.ParentThreadID(); pthid != "" {
+		attrs = append(attrs, attrParentThreadID.String(pthid))
+	}
+
+	return trace.WithAttributes(attrs...)
+}
+
+// endSpan records the error, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attrErrorCode.String(errorCode(err)))
+	}
+
+	span.End()
+}
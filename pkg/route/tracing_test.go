@@ -0,0 +1,135 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	mockdiddoc "github.com/hyperledger/aries-framework-go/pkg/mock/diddoc"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/trustbloc/edge-adapter/pkg/aries/message"
+	mockdidex "github.com/trustbloc/edge-adapter/pkg/internal/mock/didexchange"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("full flow", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := tracetest.NewSpanRecorder()
+		routerConnID := uuid.New().String()
+
+		config := config()
+		config.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return routerConnID, nil
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		_, err = c.HandleDIDDocReq(context.Background(), service.NewDIDCommMsgMap(DIDDocReq{
+			ID:   txnID,
+			Type: DIDDocReqMsgType,
+		}))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		reqID := uuid.New().String()
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     reqID,
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.NoError(t, err)
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+
+		require.Len(t, spans, 5)
+
+		didDocReq := spans[spanDIDDocReq]
+		require.Contains(t, didDocReq.Attributes(), attrMsgType.String(DIDDocReqMsgType))
+		require.Contains(t, didDocReq.Attributes(), attrMsgID.String(txnID))
+		require.Equal(t, didDocReq.SpanContext().SpanID(), spans[spanCreateDID].Parent().SpanID())
+
+		registerRouteReq := spans[spanRegisterRouteReq]
+		require.Contains(t, registerRouteReq.Attributes(), attrMsgType.String(RegisterRouteReqMsgType))
+		require.Contains(t, registerRouteReq.Attributes(), attrMsgID.String(reqID))
+		require.Contains(t, registerRouteReq.Attributes(), attrParentThreadID.String(txnID))
+		require.Contains(t, registerRouteReq.Attributes(), attrRouterConnectionID.String(routerConnID))
+		require.Equal(t, codes.Unset, registerRouteReq.Status().Code)
+
+		for _, name := range []string{spanCreateConnection, spanRegisterRoute} {
+			require.Equal(t, registerRouteReq.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := tracetest.NewSpanRecorder()
+
+		config := config()
+		config.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+		config.DIDExchangeClient = &mockdidex.MockClient{
+			CreateConnectionFunc: func(string, *did.Doc, ...didexchange.ConnectionOption) (string, error) {
+				return "", errors.New("create connection error")
+			},
+		}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		txnID := uuid.New().String()
+
+		err = c.store.Put(txnKey(txnID), []byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		didDocBytes, err := mockdiddoc.GetMockDIDDoc(t, false).JSONBytes()
+		require.NoError(t, err)
+
+		_, err = c.HandleConnReq(context.Background(), message.Msg{DIDCommMsg: service.NewDIDCommMsgMap(ConnReq{
+			ID:     uuid.New().String(),
+			Type:   RegisterRouteReqMsgType,
+			Thread: &decorator.Thread{PID: txnID},
+			Data:   &ConnReqData{DIDDoc: didDocBytes},
+		})})
+		require.Error(t, err)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+
+		for _, span := range spans {
+			require.Equal(t, codes.Error, span.Status().Code, span.Name())
+			require.Contains(t, span.Attributes(), attribute.String(string(attrErrorCode), ErrCodeConnectionCreation))
+		}
+	})
+}
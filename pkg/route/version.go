@@ -0,0 +1,87 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// protocolVersion is the major.minor version segment of a blinded routing message type.
+type protocolVersion struct {
+	major int
+	minor int
+}
+
+func parseProtocolVersion(v string) (protocolVersion, error) {
+	parts := strings.Split(v, ".")
+	if len(parts) != 2 { // nolint:gomnd // major.minor
+		return protocolVersion{}, fmt.Errorf("invalid protocol version %q", v)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return protocolVersion{}, fmt.Errorf("invalid protocol version %q : %w", v, err)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return protocolVersion{}, fmt.Errorf("invalid protocol version %q : %w", v, err)
+	}
+
+	return protocolVersion{major: major, minor: minor}, nil
+}
+
+func (v protocolVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// msgName returns the name of the blinded routing message type, ie. the segment after the protocol version, if
+// the version is compatible with one of the supported versions. Per Aries RFC 0003 versions are compatible when
+// their major versions match. An empty name is returned for any other message type.
+func (o *Service) msgName(msgType string) string {
+	rest := strings.TrimPrefix(msgType, msgTypeProtocolURI+"/")
+	if rest == msgType {
+		return ""
+	}
+
+	parts := strings.SplitN(rest, "/", 2) // nolint:gomnd // version/name
+	if len(parts) != 2 {                  // nolint:gomnd // version/name
+		return ""
+	}
+
+	v, err := parseProtocolVersion(parts[0])
+	if err != nil {
+		return ""
+	}
+
+	for _, supported := range o.versions {
+		if supported.major == v.major {
+			return parts[1]
+		}
+	}
+
+	return ""
+}
+
+// acceptMsg returns a matcher for the message types with the given name in any supported protocol version.
+func (o *Service) acceptMsg(name string) func(string) bool {
+	return func(msgType string) bool {
+		return o.msgName(msgType) == name
+	}
+}
+
+func (o *Service) supportedVersions() string {
+	versions := make([]string, len(o.versions))
+
+	for i, v := range o.versions {
+		versions[i] = v.String()
+	}
+
+	return strings.Join(versions, ", ")
+}
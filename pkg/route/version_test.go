@@ -0,0 +1,66 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgName(t *testing.T) {
+	t.Parallel()
+
+	c, err := New(config())
+	require.NoError(t, err)
+
+	for msgType, expected := range map[string]string{
+		DIDDocReqMsgType: didDocReqName,
+		"https://trustbloc.dev/blinded-routing/1.0/register-route-req": registerRouteReqName,
+		"https://trustbloc.dev/blinded-routing/1.1/diddoc-req":         didDocReqName,
+		"https://trustbloc.dev/blinded-routing/1.12/diddoc-req":        didDocReqName,
+		"https://trustbloc.dev/blinded-routing/2.0/diddoc-req":         "",
+		"https://trustbloc.dev/blinded-routing/one.0/diddoc-req":       "",
+		"https://trustbloc.dev/blinded-routing/1/diddoc-req":           "",
+		"https://trustbloc.dev/blinded-routing/1.0":                    "",
+		"https://example.com/blinded-routing/1.0/diddoc-req":           "",
+		"diddoc-req": "",
+	} {
+		require.Equal(t, expected, c.msgName(msgType), msgType)
+	}
+}
+
+func TestSupportedVersions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("configured versions", func(t *testing.T) {
+		t.Parallel()
+
+		config := config()
+		config.SupportedVersions = []string{"1.0", "2.1"}
+
+		c, err := New(config)
+		require.NoError(t, err)
+
+		require.Equal(t, "1.0, 2.1", c.supportedVersions())
+		require.Equal(t, didDocReqName, c.msgName("https://trustbloc.dev/blinded-routing/2.0/diddoc-req"))
+		require.Empty(t, c.msgName("https://trustbloc.dev/blinded-routing/3.0/diddoc-req"))
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		t.Parallel()
+
+		for _, v := range []string{"1", "a.0", "1.b"} {
+			config := config()
+			config.SupportedVersions = []string{v}
+
+			_, err := New(config)
+			require.Error(t, err, v)
+			require.Contains(t, err.Error(), "supported versions: invalid protocol version", v)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+)
+
+const (
+	// WebDIDMethod is the did method of router dids hosted on the adapter domain.
+	WebDIDMethod = "web"
+	// WebDIDDomainOpt is the Create option holding the did:web domain, including the port if any.
+	WebDIDDomainOpt = "domain"
+	// WebDIDPathOpt is the Create option holding the did:web path of the router did doc, eg. router/<txn id>.
+	WebDIDPathOpt = "path"
+)
+
+// webDID is the base did:web the router did docs are published under, each at its own path.
+type webDID struct {
+	id      string
+	domain  string
+	path    string
+	creator vdr.VDR
+}
+
+// newWebDID derives the base did:web from the endpoint, eg. https://adapter.com:8443/router gives
+// did:web:adapter.com%3A8443:router. The creator creates the router did docs.
+func newWebDID(endpoint string, creator vdr.VDR) (*webDID, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint : %w", err)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("endpoint %s has no host", endpoint)
+	}
+
+	if u.User != nil {
+		return nil, errors.New("endpoint must not hold user info")
+	}
+
+	path := strings.Trim(u.Path, "/")
+	id := "did:web:" + webDIDSegment(u.Host)
+
+	if path != "" {
+		segments := strings.Split(path, "/")
+		for i := range segments {
+			segments[i] = webDIDSegment(segments[i])
+		}
+
+		id += ":" + strings.Join(segments, ":")
+	}
+
+	return &webDID{id: id, domain: u.Host, path: path, creator: creator}, nil
+}
+
+// webDIDSegment percent-encodes s as a did:web segment, where ':' separates the segments.
+func webDIDSegment(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), ":", "%3A")
+}
+
+// apply sets the did:web id of the txn on the did doc and its services, and returns the Create options. The txn
+// id is appended to the base did and path, so that each router did doc has its own.
+func (w *webDID) apply(doc *did.Doc, txnID string) []vdr.DIDMethodOption {
+	doc.ID = w.id + ":" + webDIDSegment(txnID)
+
+	for i := range doc.Service {
+		doc.Service[i].ID = fmt.Sprintf("%s#%s-%d", doc.ID, doc.Service[i].Type, i+1)
+	}
+
+	path := url.PathEscape(txnID)
+	if w.path != "" {
+		path = w.path + "/" + path
+	}
+
+	return []vdr.DIDMethodOption{
+		vdr.WithOption(WebDIDDomainOpt, w.domain),
+		vdr.WithOption(WebDIDPathOpt, path),
+	}
+}
+
+// owns tells if the did is the base did:web or one of the router dids under it.
+func (w *webDID) owns(didID string) bool {
+	return didID == w.id || strings.HasPrefix(didID, w.id+":")
+}
@@ -0,0 +1,89 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package route
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWebDID(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		endpoint string
+		id       string
+		domain   string
+		path     string
+		err      string
+	}{
+		{endpoint: "https://adapter.com", id: "did:web:adapter.com", domain: "adapter.com"},
+		{endpoint: "https://adapter.com/", id: "did:web:adapter.com", domain: "adapter.com"},
+		{
+			endpoint: "https://adapter.com:8443/routes/x",
+			id:       "did:web:adapter.com%3A8443:routes:x",
+			domain:   "adapter.com:8443",
+			path:     "routes/x",
+		},
+		{endpoint: "adapter.com", err: "has no host"},
+		{endpoint: "https://user@adapter.com", err: "must not hold user info"},
+		{endpoint: "://adapter.com", err: "parse endpoint"},
+	} {
+		web, err := newWebDID(tc.endpoint, nil)
+		if tc.err != "" {
+			require.Error(t, err, tc.endpoint)
+			require.Contains(t, err.Error(), tc.err)
+
+			continue
+		}
+
+		require.NoError(t, err, tc.endpoint)
+		require.Equal(t, tc.id, web.id)
+		require.Equal(t, tc.domain, web.domain)
+		require.Equal(t, tc.path, web.path)
+	}
+}
+
+func TestWebDIDApply(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		endpoint string
+		txnID    string
+		id       string
+		path     string
+	}{
+		{endpoint: "https://adapter.com", txnID: "txn", id: "did:web:adapter.com:txn", path: "txn"},
+		{
+			endpoint: "https://adapter.com:8443/router",
+			txnID:    "a/b:c",
+			id:       "did:web:adapter.com%3A8443:router:a%2Fb%3Ac",
+			path:     "router/a%2Fb:c",
+		},
+	} {
+		web, err := newWebDID(tc.endpoint, nil)
+		require.NoError(t, err)
+
+		doc := &did.Doc{Service: []did.Service{{Type: "did-communication"}}}
+		opts := &vdr.DIDMethodOpts{Values: map[string]interface{}{}}
+
+		for _, opt := range web.apply(doc, tc.txnID) {
+			opt(opts)
+		}
+
+		require.Equal(t, tc.id, doc.ID)
+		require.Equal(t, tc.id+"#did-communication-1", doc.Service[0].ID)
+		require.Equal(t, tc.path, opts.Values[WebDIDPathOpt])
+		require.Equal(t, web.domain, opts.Values[WebDIDDomainOpt])
+		require.True(t, web.owns(doc.ID))
+		require.True(t, web.owns(web.id))
+		require.False(t, web.owns(web.id+"x"))
+	}
+}